@@ -0,0 +1,149 @@
+package locale
+
+import (
+	"fmt"
+	"pay-later/model"
+	"strings"
+	"sync"
+)
+
+// Locale is a language and region tag, such as en-IN, deciding how amounts
+// are written for the people reading them
+type Locale string
+
+const (
+	EnglishIndia         = Locale("en-IN")
+	EnglishUnitedStates  = Locale("en-US")
+	EnglishUnitedKingdom = Locale("en-GB")
+	GermanGermany        = Locale("de-DE")
+)
+
+type convention struct {
+	decimal      string
+	group        string
+	lakh         bool //group by 2 digits after the first 3, 1,23,45,678
+	symbolSuffix bool //1.234,56 € instead of €1,234.56
+}
+
+var conventions = map[Locale]convention{
+	EnglishIndia:         {decimal: ".", group: ",", lakh: true},
+	EnglishUnitedStates:  {decimal: ".", group: ","},
+	EnglishUnitedKingdom: {decimal: ".", group: ","},
+	GermanGermany:        {decimal: ",", group: ".", symbolSuffix: true},
+}
+
+// symbols of the currencies the locales write with a sign, the others are
+// written with their code
+var symbols = map[model.Currency]string{
+	"INR": "₹",
+	"USD": "$",
+	"EUR": "€",
+	"GBP": "£",
+	"JPY": "¥",
+}
+
+var (
+	defaultMu     sync.RWMutex
+	defaultLocale = EnglishIndia
+)
+
+func Parse(str string) (Locale, error) {
+
+	l := Locale(str)
+	if _, ok := conventions[l]; !ok {
+		return "", fmt.Errorf("invalid locale %q", str)
+	}
+
+	return l, nil
+}
+
+// Default is the locale of the reports and the command line, en-IN unless
+// changed with SetDefault
+func Default() Locale {
+
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+
+	return defaultLocale
+}
+
+func SetDefault(l Locale) error {
+
+	if _, ok := conventions[l]; !ok {
+		return fmt.Errorf("invalid locale %q", string(l))
+	}
+
+	defaultMu.Lock()
+	defaultLocale = l
+	defaultMu.Unlock()
+
+	return nil
+}
+
+// Format writes amount with the symbol, grouping and decimal separator of the
+// locale and every minor digit of the currency: ₹1,23,456.78, -$1,234.56,
+// 1.234,56 € or ¥1,235
+func (l Locale) Format(amount model.Money, currency model.Currency) string {
+
+	conv, ok := conventions[l]
+	if !ok {
+		conv = conventions[EnglishIndia]
+	}
+
+	currency = currency.OrDefault()
+
+	digits := currency.FormatAmount(amount)
+
+	sign := ""
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+
+	intPart, fracPart := digits, ""
+	if i := strings.IndexByte(digits, '.'); i >= 0 {
+		intPart, fracPart = digits[:i], digits[i+1:]
+	}
+
+	number := group(intPart, conv)
+	if fracPart != "" {
+		number += conv.decimal + fracPart
+	}
+
+	symbol, ok := symbols[currency]
+	if !ok {
+		symbol = string(currency)
+		if !conv.symbolSuffix {
+			symbol += " "
+		}
+	}
+
+	if conv.symbolSuffix {
+		return sign + number + " " + symbol
+	}
+
+	return sign + symbol + number
+}
+
+// group inserts the group separator into the integer digits
+func group(digits string, conv convention) string {
+
+	if len(digits) <= 3 {
+		return digits
+	}
+
+	head, tail := digits[:len(digits)-3], digits[len(digits)-3:]
+
+	size := 3
+	if conv.lakh {
+		size = 2
+	}
+
+	var groups []string
+	for len(head) > size {
+		groups = append([]string{head[len(head)-size:]}, groups...)
+		head = head[:len(head)-size]
+	}
+	groups = append([]string{head}, groups...)
+
+	return strings.Join(append(groups, tail), conv.group)
+}
@@ -0,0 +1,48 @@
+package lock
+
+import "sync"
+
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+// KeyLocks hands out one mutex per key, dropping it once nobody holds or
+// waits on it
+type KeyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+func NewKeyLocks() *KeyLocks {
+	return &KeyLocks{
+		locks: make(map[string]*keyLock),
+	}
+}
+
+// Lock blocks until the caller holds the mutex of key, and returns the
+// function releasing it. it is not reentrant.
+func (k *KeyLocks) Lock(key string) func() {
+
+	k.mu.Lock()
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyLock{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	l.Lock()
+
+	return func() {
+		l.Unlock()
+
+		k.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
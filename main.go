@@ -2,15 +2,110 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
 	"os"
+	"pay-later/integration/locale"
 	"pay-later/integration/log"
+	"pay-later/model"
+	"pay-later/service/billing"
 	"pay-later/service/command"
+	"pay-later/service/scheduler"
+	"pay-later/service/transfer"
 	"strings"
+	"time"
 )
 
 func main() {
 
+	dataDir := flag.String("data-dir", "data", "directory holding the write-ahead log and snapshots, empty keeps everything in memory")
+	simulatedClock := flag.Bool("simulated-clock", false, "start a clock that only moves with the clock command, for replaying months of activity")
+	clockStart := flag.String("clock-start", "", "date a new simulated clock starts at, empty for now")
+	rounding := flag.String("rounding", string(model.RoundFloor), "default rounding policy of merchant discounts: half-up, half-even, floor or ceiling")
+	loc := flag.String("locale", string(locale.EnglishIndia), "locale amounts are written in: en-IN, en-US, en-GB or de-DE")
+	cycleDay := flag.Int("cycle-day", 1, "day of the month billing cycles close on, 1 to 28")
+	dueDays := flag.Int("due-days", 20, "days from the close of a billing cycle to the due date of its statement")
+	graceDays := flag.Int("grace-days", 3, "days after the due date a payment is still on time")
+	lateFee := flag.String("late-fee", "0", "fee charged on a statement unpaid after its grace period, flat (500) or a rate of the unpaid dues (2%)")
+	lateFeeCap := flag.String("late-fee-cap", "", "most a late fee can be, empty for no cap")
+	apr := flag.String("apr", "0%", "yearly interest rate on overdue balances, accrued daily and posted when a cycle closes")
+	dayCount := flag.String("day-count", string(model.DayCountActual365), "days in a year for interest: actual/365, actual/360 or actual/actual")
+	compounding := flag.String("compounding", string(model.CompoundMonthly), "whether interest earns interest before its cycle closes: monthly or daily")
+	minimumDue := flag.String("minimum-due", "5%", "least part of the principal a statement closes with that has to be paid by its due date")
+	minimumDueFloor := flag.String("minimum-due-floor", "", "least minimum due, empty for none")
+	holdDays := flag.Int("hold-days", 7, "days an authorization holds credit before it expires uncaptured")
+	flag.Parse()
+
+	if err := model.SetDefaultRoundingPolicy(model.RoundingPolicy(*rounding)); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	if err := locale.SetDefault(locale.Locale(*loc)); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	if err := billing.SetDefaultCycleDay(*cycleDay); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	feePolicy, err := model.ParseLateFeePolicy(*lateFee, *lateFeeCap)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	rate, err := model.ParseBasisPoints(*apr)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	minimumDuePolicy, err := model.ParseMinimumDuePolicy(*minimumDue, *minimumDueFloor)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	interest := model.InterestPolicy{APR: rate, DayCount: model.DayCount(*dayCount), Compounding: model.Compounding(*compounding)}
+
+	if err := billing.SetDefaultTerms(billing.Terms{DueDays: *dueDays, GraceDays: *graceDays, LateFee: feePolicy, Interest: interest, MinimumDue: minimumDuePolicy}); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	if err := transfer.SetDefaultHoldDuration(time.Duration(*holdDays) * 24 * time.Hour); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	var clock model.Clock = model.SystemClock()
+	if *simulatedClock {
+		start := time.Now().UTC()
+		if *clockStart != "" {
+			if start, err = scheduler.ParseTime(*clockStart); err != nil {
+				fmt.Println(err)
+				os.Exit(2)
+			}
+		}
+		clock = model.NewManualClock(start)
+	}
+
+	dbMan, err := model.NewModelManager(log.NewLogger(), model.SetFileStorage(*dataDir), model.SetClock(clock))
+	if err != nil {
+		panic(err)
+	}
+
+	//a simulated clock carries on from where it was last moved to, whatever
+	//it was started at
+	if manual, ok := clock.(*model.ManualClock); ok {
+		if err := scheduler.RestoreClock(manual, dbMan); err != nil {
+			panic(err)
+		}
+	}
+
 	reader := bufio.NewReader(os.Stdin)
 
 	for {
@@ -26,9 +121,10 @@ func main() {
 		srv, err := command.NewCommand(text)
 		if err != nil {
 			fmt.Println(err)
+			continue
 		}
 
-		srv.Execute(l)
+		srv.Execute(l, dbMan)
 	}
 }
 
@@ -38,13 +134,43 @@ func main() {
 //new merchant m1 m1@merchants.com 0.5%
 //new merchant m2 m2@merchants.com 1.5%
 //new merchant m3 m3@merchants.com 1.25%
+//new merchant m4 m4@merchants.com 1.25% half-even
 //new txn user2 m1 500
+//new user user4 u4@users.com 100 USD
+//new merchant m5 m5@merchants.com 2% USD
+//fx load rates.csv
+//new txn user1 m5 2 fx
 //new txn user1 m2 300
 //new txn user1 m3 10
+//update merchant m3 rounding half-up
 //report users-at-credit-limit
 //new txn user3 m3 200
 //new txn user3 m3 300
 //report users-at-credit-limit
 //report discount m3
+//refund <transfer id printed by new txn> 100
+//dispute open <transfer id printed by new txn> 50 not delivered
+//dispute review <dispute id>
+//dispute resolve <dispute id> user
+//dispute list user3
+//auth hold user3 m3 400
+//auth capture <authorization id printed by auth hold> 350
+//auth void <authorization id>
+//auth list user3
+//report available-credit user3
 //payback user3 400
+//payback user3 500 overpay
+//credit refund user3 50
 //report total-dues
+//rebuild merchant-totals
+//verify ledger
+//report trial-balance
+//delete user user1
+//clock set 2026-01-01 (started with -simulated-clock -clock-start 2025-12-01)
+//clock advance 30d
+//statement generate
+//statement show user2 2026-01
+//statement late-fees
+//statement interest user2
+//report dues user2
+//report open-charges user2
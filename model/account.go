@@ -0,0 +1,115 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AccountType is the kind of a ledger account. user receivables are owed to
+// the lender by users, merchant payables are owed by the lender to merchants,
+// the clearing account holds sales between the user and the merchant side
+// (and so the fx position), the external account is money coming in from
+// outside, lender revenue is what the lender earns and dispute suspense
+// holds the provisional credits of disputes still open.
+type AccountType string
+
+const (
+	USER_RECEIVABLE_ACCOUNT  = AccountType("user-receivable")
+	MERCHANT_PAYABLE_ACCOUNT = AccountType("merchant-payable")
+	CLEARING_ACCOUNT         = AccountType(CLEARING_ACCOUNT_NAME)
+	EXTERNAL_ACCOUNT         = AccountType(USER_PAYBACK_ACCOUNT_NAME)
+	LENDER_REVENUE_ACCOUNT   = AccountType(LENDER_ACCOUNT_NAME)
+	DISPUTE_SUSPENSE_ACCOUNT = AccountType("dispute-suspense")
+)
+
+// Account is a ledger account in one currency. it is not stored, its balance
+// is derived from the journal lines posted to its ID.
+type Account struct {
+	Type     AccountType
+	Owner    string //user or merchant name, empty for the lender's own accounts
+	Currency Currency
+}
+
+func UserReceivable(userName string, currency Currency) Account {
+	return Account{USER_RECEIVABLE_ACCOUNT, userName, currency.OrDefault()}
+}
+
+func MerchantPayable(merchantName string, currency Currency) Account {
+	return Account{MERCHANT_PAYABLE_ACCOUNT, merchantName, currency.OrDefault()}
+}
+
+func ClearingAccount(currency Currency) Account {
+	return Account{CLEARING_ACCOUNT, "", currency.OrDefault()}
+}
+
+func ExternalAccount(currency Currency) Account {
+	return Account{EXTERNAL_ACCOUNT, "", currency.OrDefault()}
+}
+
+func LenderRevenue(currency Currency) Account {
+	return Account{LENDER_REVENUE_ACCOUNT, "", currency.OrDefault()}
+}
+
+func DisputeSuspense(currency Currency) Account {
+	return Account{DISPUTE_SUSPENSE_ACCOUNT, "", currency.OrDefault()}
+}
+
+// ID is type:owner:currency, or type:currency for the lender's accounts
+func (a Account) ID() string {
+
+	if a.Owner == "" {
+		return string(a.Type) + ":" + string(a.Currency)
+	}
+
+	return string(a.Type) + ":" + a.Owner + ":" + string(a.Currency)
+}
+
+func ParseAccountID(id string) (Account, error) {
+
+	i, j := strings.Index(id, ":"), strings.LastIndex(id, ":")
+	if i < 0 {
+		return Account{}, fmt.Errorf("invalid account %q", id)
+	}
+
+	a := Account{
+		Type:     AccountType(id[:i]),
+		Currency: Currency(id[j+1:]),
+	}
+
+	if i != j {
+		a.Owner = id[i+1 : j]
+	}
+
+	return a, nil
+}
+
+// Balance is what has been posted to an account
+type Balance struct {
+	Account Account
+	Debit   Money
+	Credit  Money
+}
+
+// Net is debits less credits, what a user owes on a receivable and minus
+// what the lender owes on a payable
+func (b Balance) Net() (Money, error) {
+	return b.Debit.Sub(b.Credit)
+}
+
+// Post adds a line to the balance
+func (b *Balance) Post(line JournalLine) error {
+
+	debit, err := b.Debit.Add(line.Debit)
+	if err != nil {
+		return err
+	}
+
+	credit, err := b.Credit.Add(line.Credit)
+	if err != nil {
+		return err
+	}
+
+	b.Debit, b.Credit = debit, credit
+
+	return nil
+}
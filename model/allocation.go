@@ -0,0 +1,49 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AllocationBucket is what part of the dues a payback went to. paybacks go
+// to fees first, then interest, then principal, each oldest charge first.
+// what is left once every charge is paid is unapplied, a credit that pays
+// later charges as they come.
+type AllocationBucket string
+
+const (
+	FEE_ALLOCATION       = AllocationBucket("fee")
+	INTEREST_ALLOCATION  = AllocationBucket("interest")
+	PRINCIPAL_ALLOCATION = AllocationBucket("principal")
+	UNAPPLIED_ALLOCATION = AllocationBucket("unapplied")
+)
+
+func init() {
+	RegisterTable(PaybackAllocation{}, AppendOnly(), Index("TransferID", "UserName", "ChargeID"))
+}
+
+// PaybackAllocation is the part of the payback TransferID that paid the
+// charge ChargeID, a late fee, interest or inter transfer. unapplied
+// allocations have no charge.
+type PaybackAllocation struct {
+	ID         uuid.UUID
+	TransferID uuid.UUID
+	UserName   string
+	Bucket     AllocationBucket
+	ChargeID   uuid.UUID
+	Amount     Money
+	Currency   Currency
+	Version    int64
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	PostedAt   time.Time
+}
+
+func (m PaybackAllocation) TableName() string {
+	return "paybackallocation"
+}
+
+func (m PaybackAllocation) PrimaryKey() string {
+	return m.ID.String()
+}
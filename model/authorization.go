@@ -0,0 +1,58 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuthorizationState is where a hold is. a pending hold reserves credit until
+// it is captured into a transfer, voided by the merchant or expires.
+type AuthorizationState string
+
+const (
+	AUTHORIZATION_PENDING  = AuthorizationState("pending")
+	AUTHORIZATION_CAPTURED = AuthorizationState("captured")
+	AUTHORIZATION_VOIDED   = AuthorizationState("voided")
+	AUTHORIZATION_EXPIRED  = AuthorizationState("expired")
+)
+
+func init() {
+	RegisterTable(Authorization{}, Index("UserName", "State"))
+}
+
+// Authorization is a hold of credit for a sale whose final amount is not
+// known yet. it posts nothing to the ledger, the user owes only what is
+// captured, as the inter transfer TransferID.
+type Authorization struct {
+	ID               uuid.UUID
+	UserName         string
+	MerchantName     string
+	Amount           Money //held from the user's credit, MerchantAmount converted at the rate of the day
+	Currency         Currency
+	MerchantAmount   Money //authorized, in the merchant's currency
+	MerchantCurrency Currency
+	Convert          bool //whether the capture may convert currencies
+	State            AuthorizationState
+	ExpiresAt        time.Time
+	CapturedAmount   Money //of the sale, in the merchant's currency
+	TransferID       uuid.UUID
+	ClosedAt         time.Time //captured, voided or expired at
+	Version          int64
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+func (m Authorization) TableName() string {
+	return "authorization"
+}
+
+func (m Authorization) PrimaryKey() string {
+	return m.ID.String()
+}
+
+// Holds tells whether the authorization still reserves credit at now. a hold
+// past its expiry reserves nothing even before it is marked expired.
+func (m Authorization) Holds(now time.Time) bool {
+	return m.State == AUTHORIZATION_PENDING && now.Before(m.ExpiresAt)
+}
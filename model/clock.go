@@ -0,0 +1,80 @@
+package model
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is where the model manager, and anything else that needs to know
+// the time, reads it from
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func SystemClock() Clock {
+	return systemClock{}
+}
+
+func (c systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// ManualClock only moves when told to, so that tests can freeze or advance
+// time deterministically
+type ManualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{
+		now: now,
+	}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *ManualClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = now
+}
+
+func (c *ManualClock) Advance(d time.Duration) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	return c.now
+}
+
+func init() {
+	RegisterTable(ClockTime{})
+}
+
+// ClockTime is where a simulated clock was last moved to, kept so that it
+// carries on from there after a restart instead of falling behind the rows
+// it already stamped
+type ClockTime struct {
+	Name      string
+	Now       time.Time
+	Version   int64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (m ClockTime) TableName() string {
+	return "clocktime"
+}
+
+func (m ClockTime) PrimaryKey() string {
+	return m.Name
+}
@@ -0,0 +1,74 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Currency is an ISO 4217 currency code. amounts in a currency are Money in
+// its minor unit, whose number of decimals is the exponent of the currency.
+type Currency string
+
+// DefaultCurrency is the currency of rows written before currencies existed,
+// and of users and merchants created without one
+const DefaultCurrency = Currency("INR")
+
+var currencyExponents = map[Currency]int{
+	"INR": 2,
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"SGD": 2,
+	"AED": 2,
+	"JPY": 0,
+	"KRW": 0,
+	"BHD": 3,
+	"KWD": 3,
+}
+
+func ParseCurrency(str string) (Currency, error) {
+
+	c := Currency(strings.ToUpper(str))
+	if _, ok := currencyExponents[c]; !ok {
+		return "", fmt.Errorf("invalid currency %q", str)
+	}
+
+	return c, nil
+}
+
+// OrDefault is the currency, or DefaultCurrency for rows that have none
+func (c Currency) OrDefault() Currency {
+
+	if c == "" {
+		return DefaultCurrency
+	}
+
+	return c
+}
+
+// Exponent is the number of decimals of the minor unit
+func (c Currency) Exponent() int {
+
+	if exp, ok := currencyExponents[c.OrDefault()]; ok {
+		return exp
+	}
+
+	return moneyScale
+}
+
+// ParseAmount reads a non negative decimal amount of the currency exactly,
+// rejecting anything finer than its minor unit
+func (c Currency) ParseAmount(str string) (Money, error) {
+
+	v, err := parseDecimal(str, c.Exponent())
+	if err != nil {
+		return 0, err
+	}
+
+	return Money(v), nil
+}
+
+// FormatAmount writes m in major units with every minor digit of the currency
+func (c Currency) FormatAmount(m Money) string {
+	return formatDecimal(int64(m), c.Exponent())
+}
@@ -0,0 +1,56 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DisputeState is where a dispute is in its workflow. a dispute is opened,
+// may be taken under review and is resolved in favour of the user, who gets
+// the disputed amount back as a chargeback, or of the merchant, who keeps it.
+type DisputeState string
+
+const (
+	DISPUTE_OPENED            = DisputeState("opened")
+	DISPUTE_UNDER_REVIEW      = DisputeState("under-review")
+	DISPUTE_RESOLVED_USER     = DisputeState("resolved-user")
+	DISPUTE_RESOLVED_MERCHANT = DisputeState("resolved-merchant")
+)
+
+func init() {
+	RegisterTable(Dispute{}, Index("TransferID", "UserName", "State"))
+}
+
+// Dispute is a user contesting Amount of the sale of the inter transfer
+// TransferID, in the merchant's currency. while it is open the user is given
+// ProvisionalCredit, the disputed part of what they were charged.
+type Dispute struct {
+	ID                uuid.UUID
+	TransferID        uuid.UUID
+	UserName          string
+	MerchantName      string
+	Amount            Money
+	MerchantCurrency  Currency
+	ProvisionalCredit Money
+	Currency          Currency //of the user
+	State             DisputeState
+	Reason            string
+	ResolvedAt        time.Time
+	Version           int64
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
+
+func (m Dispute) TableName() string {
+	return "dispute"
+}
+
+func (m Dispute) PrimaryKey() string {
+	return m.ID.String()
+}
+
+// IsResolved tells whether the dispute was decided either way
+func (m Dispute) IsResolved() bool {
+	return m.State == DISPUTE_RESOLVED_USER || m.State == DISPUTE_RESOLVED_MERCHANT
+}
@@ -0,0 +1,24 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+// VersionConflictError is returned by Upsert and Commit when the stored row
+// has moved past the version the write was based on
+type VersionConflictError struct {
+	Table    string
+	Key      string
+	Expected int64
+	Actual   int64
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("%s %s was modified concurrently: expected version %d, found %d", e.Table, e.Key, e.Expected, e.Actual)
+}
+
+func IsVersionConflict(err error) bool {
+	var conflict *VersionConflictError
+	return errors.As(err, &conflict)
+}
@@ -0,0 +1,360 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"pay-later/integration/log"
+)
+
+const (
+	walFileName             = "wal.log"
+	snapshotFileName        = "snapshot.json"
+	defaultSnapshotInterval = 1000
+)
+
+// walRecord is a single line of the write-ahead log, holding every write of
+// one commit so that a torn line drops the whole commit
+type walRecord struct {
+	Seq    uint64     `json:"seq"`
+	Writes []walWrite `json:"writes"`
+}
+
+type walWrite struct {
+	Table  string          `json:"table"`
+	Key    string          `json:"key"`
+	Data   json.RawMessage `json:"data,omitempty"`
+	Delete bool            `json:"delete,omitempty"` //the row was removed, Data is empty
+}
+
+// snapshot is the compacted state of every table, valid up to Seq
+type snapshot struct {
+	Seq    uint64                                `json:"seq"`
+	Tables map[string]map[string]json.RawMessage `json:"tables"`
+}
+
+// fileModelManager keeps the in memory tables of modelManager durable by
+// appending every write to a log before applying it
+type fileModelManager struct {
+	*modelManager
+	dir              string
+	wal              *os.File
+	seq              uint64
+	sinceSnapshot    int
+	snapshotInterval int
+	failed           error //set when the log could not be restored after a failed append
+}
+
+func newFileModelManager(m *modelManager, opts *managerOpts) (*fileModelManager, error) {
+
+	if err := os.MkdirAll(opts.dir, 0755); err != nil {
+		return nil, err
+	}
+
+	f := &fileModelManager{
+		modelManager:     m,
+		dir:              opts.dir,
+		snapshotInterval: opts.snapshotInterval,
+	}
+
+	if err := f.loadSnapshot(); err != nil {
+		return nil, err
+	}
+
+	if err := f.replayWAL(); err != nil {
+		return nil, err
+	}
+
+	wal, err := os.OpenFile(filepath.Join(f.dir, walFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	f.wal = wal
+
+	return f, nil
+}
+
+func (f *fileModelManager) Upsert(model Model) (Model, error) {
+
+	changes, err := f.commit([]write{{model: model}})
+	if err != nil {
+		return nil, err
+	}
+
+	return changes[0].row, nil
+}
+
+func (f *fileModelManager) Delete(model Model) error {
+	_, err := f.commit([]write{{model: model, remove: true}})
+	return err
+}
+
+func (f *fileModelManager) Begin() (Tx, error) {
+	return newTx(f.modelManager, f), nil
+}
+
+func (f *fileModelManager) commit(writes []write) ([]change, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.failed != nil {
+		return nil, fmt.Errorf("write-ahead log is unusable, restart to recover: %v", f.failed)
+	}
+
+	changes, err := f.prepare(writes)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := walRecord{
+		Seq:    f.seq + 1,
+		Writes: make([]walWrite, len(changes)),
+	}
+
+	for i, c := range changes {
+		rec.Writes[i] = walWrite{
+			Table:  c.table.name,
+			Key:    c.key,
+			Delete: c.row == nil,
+		}
+
+		if c.row == nil {
+			continue
+		}
+
+		data, err := json.Marshal(c.row)
+		if err != nil {
+			return nil, err
+		}
+		rec.Writes[i].Data = data
+	}
+
+	if err := f.append(rec); err != nil {
+		f.l.ErrorD("can not able to append to write-ahead log", log.Fields{"seq": rec.Seq, "error": err.Error()})
+		return nil, err
+	}
+	f.seq = rec.Seq
+
+	for _, c := range changes {
+		f.apply(c)
+	}
+
+	f.sinceSnapshot++
+	if f.snapshotInterval > 0 && f.sinceSnapshot >= f.snapshotInterval {
+		if err := f.snapshot(); err != nil { //the log still has every write, so this is not fatal
+			f.l.ErrorD("can not able to write snapshot", log.Fields{"error": err.Error()})
+		}
+	}
+
+	return changes, nil
+}
+
+// append writes rec to the log. a record that fails to be written, even in
+// part or only to sync, is cut off again so that a failed commit is never
+// replayed; when even that fails no further commit is taken.
+func (f *fileModelManager) append(rec walRecord) error {
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.wal.Stat()
+	if err != nil {
+		return err
+	}
+
+	_, err = f.wal.Write(append(line, '\n'))
+	if err == nil {
+		err = f.wal.Sync()
+	}
+
+	if err != nil {
+		if terr := f.wal.Truncate(info.Size()); terr != nil {
+			f.failed = terr
+		}
+		return err
+	}
+
+	return nil
+}
+
+// snapshot writes every table to a new snapshot file and truncates the log.
+// the caller holds mu for writing.
+// the snapshot is renamed into place, so a crash leaves either the old or
+// the new one, and records already in it are skipped on replay.
+func (f *fileModelManager) snapshot() error {
+
+	data, err := json.Marshal(struct {
+		Seq    uint64                      `json:"seq"`
+		Tables map[string]map[string]Model `json:"tables"`
+	}{f.seq, f.dataBase})
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(f.dir, snapshotFileName)
+	tmp := path + ".tmp"
+
+	if err := writeFileSync(tmp, data); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	if err := syncDir(f.dir); err != nil {
+		return err
+	}
+
+	if err := f.wal.Truncate(0); err != nil {
+		return err
+	}
+
+	f.sinceSnapshot = 0
+
+	return nil
+}
+
+func (f *fileModelManager) loadSnapshot() error {
+
+	data, err := ioutil.ReadFile(filepath.Join(f.dir, snapshotFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("can not able to read snapshot: %v", err)
+	}
+
+	for table, rows := range snap.Tables {
+		for key, row := range rows {
+			if err := f.restore(walWrite{Table: table, Key: key, Data: row}); err != nil {
+				return err
+			}
+		}
+	}
+
+	f.seq = snap.Seq
+
+	return nil
+}
+
+// replayWAL applies every record written after the snapshot. a torn last
+// line from a crash mid-append is cut off, anything else unreadable is
+// reported as corruption.
+func (f *fileModelManager) replayWAL() error {
+
+	path := filepath.Join(f.dir, walFileName)
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(line)) > 0 {
+				f.l.WarnD("truncating incomplete write-ahead log record", log.Fields{"offset": offset})
+				return file.Truncate(offset)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var rec walRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return fmt.Errorf("corrupt write-ahead log at offset %d: %v", offset, err)
+		}
+
+		offset += int64(len(line))
+
+		if rec.Seq <= f.seq {
+			continue
+		}
+
+		for _, w := range rec.Writes {
+			if err := f.restore(w); err != nil {
+				return err
+			}
+		}
+
+		f.seq = rec.Seq
+		f.sinceSnapshot++
+	}
+}
+
+// restore decodes a logged row back into its model and applies it
+func (f *fileModelManager) restore(w walWrite) error {
+
+	t, ok := registry[w.Table]
+	if !ok {
+		return fmt.Errorf("invalid table name: %s", w.Table)
+	}
+
+	if w.Delete {
+		f.remove(t, w.Key)
+		return nil
+	}
+
+	model, err := t.decode(w.Data)
+	if err != nil {
+		return err
+	}
+
+	f.put(t, model)
+
+	return nil
+}
+
+func writeFileSync(path string, data []byte) error {
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func syncDir(dir string) error {
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
@@ -0,0 +1,164 @@
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"pay-later/integration/log"
+	"testing"
+)
+
+func newTestFileManager(t *testing.T, dir string, opts ...Option) *fileModelManager {
+	t.Helper()
+
+	m, err := NewModelManager(log.NewLogger(log.SetOutput(ioutil.Discard)), append(opts, SetFileStorage(dir))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, ok := m.(*fileModelManager)
+	if !ok {
+		t.Fatalf("got %T, want a file model manager", m)
+	}
+
+	t.Cleanup(func() { f.wal.Close() })
+
+	return f
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return dir
+}
+
+func mustUpsertUser(t *testing.T, m ModelManager, name string, limit Money) {
+	t.Helper()
+
+	if _, err := m.Upsert(User{Name: name, Email: name + "@users.com", CreditLimit: limit}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// assertUsers checks the users stored in m are exactly want, by name and limit
+func assertUsers(t *testing.T, m ModelManager, want map[string]Money) {
+	t.Helper()
+
+	rows, err := m.GetAll(User{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != len(want) {
+		t.Fatalf("got %d users, want %d", len(rows), len(want))
+	}
+
+	for _, row := range rows {
+		u := row.(User)
+		if limit, ok := want[u.Name]; !ok || limit != u.CreditLimit {
+			t.Errorf("got user %s with limit %s, want %v", u.Name, u.CreditLimit, want)
+		}
+	}
+}
+
+func TestFileModelManagerReopensSnapshotAndLogTail(t *testing.T) {
+
+	dir := tempDir(t)
+
+	f := newTestFileManager(t, dir, SetSnapshotInterval(2))
+	mustUpsertUser(t, f, "u1", 100)
+	mustUpsertUser(t, f, "u2", 200) //compacted into the snapshot
+	mustUpsertUser(t, f, "u3", 300) //left in the log
+
+	if _, err := os.Stat(filepath.Join(dir, snapshotFileName)); err != nil {
+		t.Fatalf("no snapshot: %v", err)
+	}
+	f.wal.Close()
+
+	reopened := newTestFileManager(t, dir, SetSnapshotInterval(2))
+	assertUsers(t, reopened, map[string]Money{"u1": 100, "u2": 200, "u3": 300})
+
+	if reopened.seq != 3 {
+		t.Errorf("reopened at seq %d, want 3", reopened.seq)
+	}
+}
+
+func TestFileModelManagerCutsTornLastRecord(t *testing.T) {
+
+	dir := tempDir(t)
+
+	f := newTestFileManager(t, dir)
+	mustUpsertUser(t, f, "u1", 100)
+	mustUpsertUser(t, f, "u2", 200)
+	f.wal.Close()
+
+	path := filepath.Join(dir, walFileName)
+
+	complete, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//a crash in the middle of appending the third commit
+	torn := append(append([]byte{}, complete...), []byte(`{"seq":3,"writes":[{"table":"user","key":"u3","da`)...)
+	if err := ioutil.WriteFile(path, torn, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := newTestFileManager(t, dir)
+	assertUsers(t, reopened, map[string]Money{"u1": 100, "u2": 200})
+
+	cut, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(cut) != string(complete) {
+		t.Errorf("torn record left in the log:\n%s", cut)
+	}
+
+	//later commits go after the last complete record and replay again
+	mustUpsertUser(t, reopened, "u3", 300)
+	reopened.wal.Close()
+
+	assertUsers(t, newTestFileManager(t, dir), map[string]Money{"u1": 100, "u2": 200, "u3": 300})
+}
+
+func TestFileModelManagerRefusesCommitsAfterUnrecoverableAppend(t *testing.T) {
+
+	dir := tempDir(t)
+
+	f := newTestFileManager(t, dir)
+	mustUpsertUser(t, f, "u1", 100)
+	f.wal.Close()
+
+	//a log that can be neither written nor cut back
+	readOnly, err := os.Open(filepath.Join(dir, walFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.wal = readOnly
+
+	if _, err := f.Upsert(User{Name: "u2", Email: "u2@users.com"}); err == nil {
+		t.Fatal("commit succeeded on a read-only log")
+	}
+
+	if f.failed == nil {
+		t.Fatal("log not marked unusable after it could not be cut back")
+	}
+
+	if _, err := f.Upsert(User{Name: "u3", Email: "u3@users.com"}); err == nil {
+		t.Fatal("commit taken after the log became unusable")
+	}
+
+	assertUsers(t, f, map[string]Money{"u1": 100})
+	readOnly.Close()
+
+	assertUsers(t, newTestFileManager(t, dir), map[string]Money{"u1": 100})
+}
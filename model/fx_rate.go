@@ -0,0 +1,81 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterTable(FXRate{}, Index("From"))
+}
+
+// fxRateScale is the number of decimals a rate is stored with
+const fxRateScale = 8
+
+// FXRate is how many units of To one unit of From buys, in 10^-8 units
+type FXRate struct {
+	From      Currency
+	To        Currency
+	Rate      int64
+	Version   int64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (m FXRate) TableName() string {
+	return "fxrate"
+}
+
+func (m FXRate) PrimaryKey() string {
+	return string(m.From) + "/" + string(m.To)
+}
+
+// ParseFXRate reads a positive decimal rate with up to 8 decimals
+func ParseFXRate(str string) (int64, error) {
+
+	v, err := parseDecimal(str, fxRateScale)
+	if err != nil {
+		return 0, err
+	}
+
+	if v == 0 {
+		return 0, fmt.Errorf("invalid rate %q", str)
+	}
+
+	return v, nil
+}
+
+func (m FXRate) String() string {
+	return fmt.Sprintf("%s/%s %s", m.From, m.To, strings.TrimRight(strings.TrimRight(formatDecimal(m.Rate, fxRateScale), "0"), "."))
+}
+
+// Convert turns an amount of From into To at the rate, rounding to the minor
+// unit of To by the policy
+func (m FXRate) Convert(amount Money, policy RoundingPolicy) (Money, error) {
+
+	if err := policy.Validate(); err != nil {
+		return 0, err
+	}
+
+	ten := big.NewInt(10)
+
+	n := new(big.Int).Mul(big.NewInt(int64(amount)), big.NewInt(m.Rate))
+	d := new(big.Int).Exp(ten, big.NewInt(fxRateScale), nil)
+
+	//amount is in minor units of From, the result in minor units of To
+	if shift := m.To.Exponent() - m.From.Exponent(); shift > 0 {
+		n.Mul(n, new(big.Int).Exp(ten, big.NewInt(int64(shift)), nil))
+	} else if shift < 0 {
+		d.Mul(d, new(big.Int).Exp(ten, big.NewInt(int64(-shift)), nil))
+	}
+
+	q := policy.divide(n, d)
+	if !q.IsInt64() || q.Int64() == math.MinInt64 {
+		return 0, ErrMoneyOverflow
+	}
+
+	return Money(q.Int64()), nil
+}
@@ -0,0 +1,44 @@
+package model
+
+import "testing"
+
+func TestFXRateConvert(t *testing.T) {
+
+	tests := []struct {
+		from, to Currency
+		rate     string
+		amount   Money
+		want     [4]Money //floor, ceiling, half-up, half-even
+	}{
+		//1.00 USD is 151.5 JPY, yen have no minor unit
+		{"USD", "JPY", "151.5", 100, [4]Money{151, 152, 152, 152}},
+		{"USD", "JPY", "152.5", 100, [4]Money{152, 153, 153, 152}},
+		//1000 JPY is 6.60 USD exactly
+		{"JPY", "USD", "0.0066", 1000, [4]Money{660, 660, 660, 660}},
+		//1.00 USD is 0.376 BHD, in fils
+		{"USD", "BHD", "0.376", 100, [4]Money{376, 376, 376, 376}},
+		//1 fils is 0.221123 INR, 22.1123 paise
+		{"BHD", "INR", "221.123", 1, [4]Money{22, 23, 22, 22}},
+		{"INR", "USD", "0.012", 0, [4]Money{0, 0, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		rate, err := ParseFXRate(tt.rate)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fx := FXRate{From: tt.from, To: tt.to, Rate: rate}
+
+		for i, p := range policies {
+			got, err := fx.Convert(tt.amount, p)
+			if err != nil || got != tt.want[i] {
+				t.Errorf("%s: %s %s at %s = %s %s, %v, want %s", p, tt.from, tt.from.FormatAmount(tt.amount), tt.rate, tt.to.FormatAmount(got), tt.to, err, tt.to.FormatAmount(tt.want[i]))
+			}
+		}
+	}
+
+	if _, err := (FXRate{From: "USD", To: "JPY", Rate: 15150000000}).Convert(9223372036854775807, RoundFloor); err != ErrMoneyOverflow {
+		t.Errorf("got %v, want ErrMoneyOverflow", err)
+	}
+}
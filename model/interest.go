@@ -0,0 +1,144 @@
+package model
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// DayCount decides how many days a year has when an annual rate is turned
+// into a daily one
+type DayCount string
+
+const (
+	DayCountActual365    = DayCount("actual/365")    //every year has 365 days
+	DayCountActual360    = DayCount("actual/360")    //every year has 360 days
+	DayCountActualActual = DayCount("actual/actual") //365 days, 366 in leap years
+)
+
+func ParseDayCount(str string) (DayCount, error) {
+
+	d := DayCount(str)
+	if err := d.Validate(); err != nil {
+		return "", err
+	}
+
+	return d, nil
+}
+
+func (d DayCount) Validate() error {
+
+	switch d {
+	case DayCountActual365, DayCountActual360, DayCountActualActual:
+		return nil
+	}
+
+	return fmt.Errorf("invalid day count %q, use actual/365, actual/360 or actual/actual", string(d))
+}
+
+// DaysInYear is the number of days of the year holding day
+func (d DayCount) DaysInYear(day time.Time) int64 {
+
+	switch d {
+	case DayCountActual360:
+		return 360
+	case DayCountActualActual:
+		if y := day.Year(); y%4 == 0 && (y%100 != 0 || y%400 == 0) {
+			return 366
+		}
+	}
+
+	return 365
+}
+
+// Compounding decides whether interest accrued in a cycle earns interest
+// itself before the cycle closes. interest posted at the close is part of the
+// balance from then on either way.
+type Compounding string
+
+const (
+	CompoundMonthly = Compounding("monthly") //daily interest on the balance alone
+	CompoundDaily   = Compounding("daily")   //daily interest on the balance and the interest accrued so far
+)
+
+func ParseCompounding(str string) (Compounding, error) {
+
+	c := Compounding(str)
+	if err := c.Validate(); err != nil {
+		return "", err
+	}
+
+	return c, nil
+}
+
+func (c Compounding) Validate() error {
+
+	switch c {
+	case CompoundMonthly, CompoundDaily:
+		return nil
+	}
+
+	return fmt.Errorf("invalid compounding %q, use monthly or daily", string(c))
+}
+
+// InterestPolicy is the interest charged on overdue balances, APR basis
+// points a year accrued daily
+type InterestPolicy struct {
+	APR         int
+	DayCount    DayCount
+	Compounding Compounding
+}
+
+func (p InterestPolicy) Validate() error {
+
+	if p.APR < 0 {
+		return fmt.Errorf("invalid apr")
+	}
+
+	if err := p.DayCount.Validate(); err != nil {
+		return err
+	}
+
+	return p.Compounding.Validate()
+}
+
+// Accrue is the interest on balance(day) for every day from start up to,
+// not including, end, kept exact and rounded once by rounding
+func (p InterestPolicy) Accrue(start time.Time, end time.Time, rounding RoundingPolicy, balance func(time.Time) (Money, error)) (Money, error) {
+
+	if err := rounding.Validate(); err != nil {
+		return 0, err
+	}
+
+	if p.APR == 0 {
+		return 0, nil
+	}
+
+	accrued := new(big.Rat)
+
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		b, err := balance(day)
+		if err != nil {
+			return 0, err
+		}
+
+		base := new(big.Rat).SetInt64(int64(b))
+		if p.Compounding == CompoundDaily {
+			base.Add(base, accrued)
+		}
+
+		if base.Sign() <= 0 {
+			continue
+		}
+
+		base.Mul(base, big.NewRat(int64(p.APR), rateScale*p.DayCount.DaysInYear(day)))
+		accrued.Add(accrued, base)
+	}
+
+	q := rounding.divide(accrued.Num(), accrued.Denom())
+	if !q.IsInt64() {
+		return 0, ErrMoneyOverflow
+	}
+
+	return Money(q.Int64()), nil
+}
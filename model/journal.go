@@ -0,0 +1,101 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func init() {
+	RegisterTable(JournalEntry{}, AppendOnly(), Index("TransferID", "Type"))
+	RegisterTable(JournalLine{}, AppendOnly(), Index("EntryID", "AccountID", "TransferID"))
+}
+
+// JournalEntry is one balanced posting to the ledger, in one currency. its
+// lines debit and credit accounts by the same total.
+type JournalEntry struct {
+	ID         uuid.UUID
+	TransferID uuid.UUID
+	Type       TransactionType
+	Currency   Currency
+	Version    int64
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	PostedAt   time.Time
+}
+
+func (m JournalEntry) TableName() string {
+	return "journalentry"
+}
+
+func (m JournalEntry) PrimaryKey() string {
+	return m.ID.String()
+}
+
+// JournalLine debits or credits one account for an entry
+type JournalLine struct {
+	ID         uuid.UUID
+	EntryID    uuid.UUID
+	TransferID uuid.UUID
+	AccountID  string
+	Currency   Currency
+	Debit      Money
+	Credit     Money
+	Version    int64
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	PostedAt   time.Time
+}
+
+func (m JournalLine) TableName() string {
+	return "journalline"
+}
+
+func (m JournalLine) PrimaryKey() string {
+	return m.ID.String()
+}
+
+func Debit(account Account, amount Money) JournalLine {
+	return JournalLine{AccountID: account.ID(), Currency: account.Currency, Debit: amount}
+}
+
+func Credit(account Account, amount Money) JournalLine {
+	return JournalLine{AccountID: account.ID(), Currency: account.Currency, Credit: amount}
+}
+
+// ValidateEntry checks that the lines of entry are in its currency, each one
+// either a debit or a credit, and that they balance
+func ValidateEntry(entry JournalEntry, lines []JournalLine) error {
+
+	if len(lines) < 2 {
+		return fmt.Errorf("journal entry needs at least two lines")
+	}
+
+	var debits, credits Money
+	var err error
+
+	for _, line := range lines {
+		if line.Currency != entry.Currency {
+			return fmt.Errorf("journal line on %s is in %s, entry is in %s", line.AccountID, line.Currency, entry.Currency)
+		}
+
+		if line.Debit.IsNegative() || line.Credit.IsNegative() || (line.Debit == 0) == (line.Credit == 0) {
+			return fmt.Errorf("journal line on %s has to either debit or credit a positive amount", line.AccountID)
+		}
+
+		if debits, err = debits.Add(line.Debit); err != nil {
+			return err
+		}
+
+		if credits, err = credits.Add(line.Credit); err != nil {
+			return err
+		}
+	}
+
+	if debits != credits {
+		return fmt.Errorf("journal entry does not balance, debits %s credits %s", entry.Currency.FormatAmount(debits), entry.Currency.FormatAmount(credits))
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// feeScale is the number of decimals fee amounts are kept with, finer than
+// the minor unit of any currency so that one policy fits all of them
+const feeScale = 4
+
+// LateFeePolicy is what a statement left unpaid past its grace period is
+// charged: a flat amount, or Rate basis points of what is left unpaid, at most
+// the cap. amounts are in major units of the user's currency.
+type LateFeePolicy struct {
+	Rate int
+	flat int64 //in 10^-feeScale major units
+	cap  int64 //0 for no cap
+}
+
+// ParseLateFeePolicy reads a flat fee such as "500" or a rate such as "2.5%",
+// and a cap on it such as "1000", empty for none. a fee of "0" charges nothing.
+func ParseLateFeePolicy(fee string, cap string) (LateFeePolicy, error) {
+
+	var p LateFeePolicy
+	var err error
+
+	if strings.HasSuffix(fee, "%") {
+		if p.Rate, err = ParseBasisPoints(fee); err != nil {
+			return p, fmt.Errorf("invalid late fee %q", fee)
+		}
+	} else if p.flat, err = parseDecimal(fee, feeScale); err != nil {
+		return p, fmt.Errorf("invalid late fee %q", fee)
+	}
+
+	if cap != "" {
+		if p.cap, err = parseDecimal(cap, feeScale); err != nil {
+			return p, fmt.Errorf("invalid late fee cap %q", cap)
+		}
+	}
+
+	return p, nil
+}
+
+// IsZero tells whether the policy never charges anything
+func (p LateFeePolicy) IsZero() bool {
+	return p.Rate == 0 && p.flat == 0
+}
+
+// Fee is the late fee on unpaid dues in currency, a rate rounded by rounding
+func (p LateFeePolicy) Fee(unpaid Money, currency Currency, rounding RoundingPolicy) (Money, error) {
+
+	if unpaid <= 0 {
+		return 0, nil
+	}
+
+	fee := inCurrency(p.flat, currency)
+	if p.Rate != 0 {
+		var err error
+		if fee, _, err = rounding.ApplyRate(unpaid, p.Rate); err != nil {
+			return 0, err
+		}
+	}
+
+	if cap := inCurrency(p.cap, currency); p.cap != 0 && fee > cap {
+		fee = cap
+	}
+
+	return fee, nil
+}
+
+func (p LateFeePolicy) String() string {
+
+	fee := formatDecimal(p.flat, feeScale)
+	if p.Rate != 0 {
+		fee = FormatBasisPoints(p.Rate) + "%"
+	}
+
+	if p.cap != 0 {
+		fee += " up to " + formatDecimal(p.cap, feeScale)
+	}
+
+	return fee
+}
+
+// inCurrency is v, in 10^-feeScale major units, in the minor unit of
+// currency, dropping what is finer
+func inCurrency(v int64, currency Currency) Money {
+
+	for i := currency.Exponent(); i < feeScale; i++ {
+		v /= 10
+	}
+
+	return Money(v)
+}
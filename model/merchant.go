@@ -1,9 +1,21 @@
 package model
 
+import "time"
+
+func init() {
+	RegisterTable(Merchant{}, SoftDelete())
+}
+
 type Merchant struct {
-	Name     string
-	Email    string
-	Discount int //store as precision of 2 digits after decimal
+	Name      string
+	Email     string
+	Currency  Currency       //of the sales settled to the merchant, empty for DefaultCurrency
+	Discount  int            //store as precision of 2 digits after decimal
+	Rounding  RoundingPolicy //empty for the default rounding policy
+	Version   int64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt time.Time
 }
 
 func (m Merchant) TableName() string {
@@ -14,6 +26,20 @@ func (m Merchant) PrimaryKey() string {
 	return m.Name
 }
 
-func (m Merchant) GetDiscountedAmount(amount int) float64 {
-	return (float64(m.Discount) / float64(10000)) * float64(amount)
+// RoundingPolicy is the policy the discounts of the merchant are rounded by
+func (m Merchant) RoundingPolicy() RoundingPolicy {
+
+	if m.Rounding == "" {
+		return DefaultRoundingPolicy()
+	}
+
+	return m.Rounding
+}
+
+// GetDiscountedAmount is the discount on amount rounded to the minor unit,
+// with the rounding residual as returned by RoundingPolicy.ApplyRate. the net
+// settled to the merchant is amount less the discount, so the two always add
+// up to amount exactly.
+func (m Merchant) GetDiscountedAmount(amount Money) (Money, int64, error) {
+	return m.RoundingPolicy().ApplyRate(amount, m.Discount)
 }
@@ -0,0 +1,103 @@
+package model
+
+import "time"
+
+func init() {
+	RegisterTable(MerchantTotals{})
+}
+
+// MerchantTotals are the running totals of a merchant, maintained as its
+// transactions are written so that reports do not walk the ledger
+type MerchantTotals struct {
+	MerchantName   string
+	Currency       Currency //of the merchant, every amount below is in it
+	DiscountEarned Money    //MERCHANT_DISCOUNT_CREDIT less DISCOUNT_CLAWBACK amounts
+	GrossVolume    Money    //discount plus net paid
+	NetPaid        Money    //USER_MERCHANT_TRANSFER less MERCHANT_REFUND amounts
+	TransferCount  int
+	Version        int64
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+func (m MerchantTotals) TableName() string {
+	return "merchanttotals"
+}
+
+func (m MerchantTotals) PrimaryKey() string {
+	return m.MerchantName
+}
+
+// Apply adds txn to the totals if it is one of the merchant's transactions
+func (m *MerchantTotals) Apply(txn Transaction) error {
+
+	var err error
+	totals := *m
+
+	switch {
+	case txn.Type == USER_MERCHANT_TRANSFER && txn.DestinationName == m.MerchantName:
+		if totals.NetPaid, err = totals.NetPaid.Add(txn.Amount); err != nil {
+			return err
+		}
+		totals.TransferCount++
+
+	case txn.Type == MERCHANT_DISCOUNT_CREDIT && txn.SourceName == m.MerchantName:
+		if totals.DiscountEarned, err = totals.DiscountEarned.Add(txn.Amount); err != nil {
+			return err
+		}
+
+	case txn.Type == MERCHANT_REFUND && txn.SourceName == m.MerchantName:
+		if totals.NetPaid, err = totals.NetPaid.Sub(txn.Amount); err != nil {
+			return err
+		}
+
+	case txn.Type == DISCOUNT_CLAWBACK && txn.DestinationName == m.MerchantName:
+		if totals.DiscountEarned, err = totals.DiscountEarned.Sub(txn.Amount); err != nil {
+			return err
+		}
+
+	default:
+		return nil
+	}
+
+	//refunds take back from the volume what their sale added to it
+	volume := txn.Amount
+	if txn.Type == MERCHANT_REFUND || txn.Type == DISCOUNT_CLAWBACK {
+		volume = -volume
+	}
+
+	if totals.GrossVolume, err = totals.GrossVolume.Add(volume); err != nil {
+		return err
+	}
+
+	if totals.Currency == "" {
+		totals.Currency = txn.Currency
+	}
+
+	*m = totals
+
+	return nil
+}
+
+// SameTotals compares the totals ignoring the row version and timestamps
+func (m MerchantTotals) SameTotals(o MerchantTotals) bool {
+	return m.MerchantName == o.MerchantName &&
+		m.Currency == o.Currency &&
+		m.DiscountEarned == o.DiscountEarned &&
+		m.GrossVolume == o.GrossVolume &&
+		m.NetPaid == o.NetPaid &&
+		m.TransferCount == o.TransferCount
+}
+
+// TotalsMerchantName is the merchant whose totals txn contributes to, or ""
+func TotalsMerchantName(txn Transaction) string {
+
+	switch txn.Type {
+	case USER_MERCHANT_TRANSFER, DISCOUNT_CLAWBACK:
+		return txn.DestinationName
+	case MERCHANT_DISCOUNT_CREDIT, MERCHANT_REFUND:
+		return txn.SourceName
+	}
+
+	return ""
+}
@@ -0,0 +1,72 @@
+package model
+
+import "fmt"
+
+// MinimumDuePolicy is the least a statement has to be paid by its due date
+// to not be late: Rate basis points of the principal it closed with, at least
+// the floor, plus the fees and interest charged in its cycle. the floor is in
+// major units of the user's currency.
+type MinimumDuePolicy struct {
+	Rate  int
+	floor int64 //in 10^-feeScale major units
+}
+
+// ParseMinimumDuePolicy reads a rate such as "5%" and a floor such as "100",
+// empty for none
+func ParseMinimumDuePolicy(rate string, floor string) (MinimumDuePolicy, error) {
+
+	var p MinimumDuePolicy
+	var err error
+
+	if p.Rate, err = ParseBasisPoints(rate); err != nil || p.Rate > rateScale {
+		return p, fmt.Errorf("invalid minimum due %q", rate)
+	}
+
+	if floor != "" {
+		if p.floor, err = parseDecimal(floor, feeScale); err != nil {
+			return p, fmt.Errorf("invalid minimum due floor %q", floor)
+		}
+	}
+
+	return p, nil
+}
+
+// MinimumDue is the minimum amount due of statement, never more than it
+// closed with. the rate is rounded up.
+func (p MinimumDuePolicy) MinimumDue(statement Statement) (Money, error) {
+
+	if statement.ClosingBalance <= 0 {
+		return 0, nil
+	}
+
+	charges, err := statement.Fees.Add(statement.Interest)
+	if err != nil {
+		return 0, err
+	}
+
+	principal, err := statement.ClosingBalance.Sub(charges)
+	if err != nil {
+		return 0, err
+	}
+
+	var due Money
+	if principal > 0 {
+		if due, _, err = RoundCeiling.ApplyRate(principal, p.Rate); err != nil {
+			return 0, err
+		}
+	}
+
+	if floor := inCurrency(p.floor, statement.Currency); due < floor {
+		due = floor
+	}
+
+	if due, err = due.Add(charges); err != nil {
+		return 0, err
+	}
+
+	if due > statement.ClosingBalance {
+		due = statement.ClosingBalance
+	}
+
+	return due, nil
+}
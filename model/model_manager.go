@@ -1,19 +1,14 @@
 package model
 
 import (
+	"errors"
 	"fmt"
 	"pay-later/integration/log"
 	"reflect"
+	"sync"
 )
 
-// database tables, with indexing on `id` and `name` field
-var dataBase = map[string]interface{}{
-	"user":                make(map[string]User),
-	"merchant":            make(map[string]Merchant),
-	"transaction":         make(map[string]Transaction),
-	"userpaybacktransfer": make(map[string]UserPaybackTransfer),
-	"intertransfer":       make(map[string]InterTransfer),
-}
+var ErrAppendOnly = errors.New("rows of an append-only table can not be deleted")
 
 type Model interface {
 	TableName() string
@@ -24,294 +19,382 @@ type ModelManager interface {
 	Upsert(Model) (Model, error)
 	GetWithPrimaryKey(Model) (Model, bool, error)
 	GetAll(Model) ([]Model, error)
+	Query(Model, Query) ([]Model, error)
+	Delete(Model) error
+	Begin() (Tx, error)
+	Clock() Clock
 }
 
+// modelManager keeps every registered table in memory, with indexing on the
+// primary key of each row and on the fields the table declared indexes for.
+// it is safe for concurrent use, mu guards dataBase and indexes.
 type modelManager struct {
-	l log.Logger
+	l        log.Logger
+	clock    Clock
+	mu       sync.RWMutex
+	dataBase map[string]map[string]Model
+	indexes  map[string]map[string]index //table -> field -> index
 }
 
-func NewModelManager(log log.Logger) ModelManager {
-	return &modelManager{
-		log,
-	}
+type managerOpts struct {
+	dir              string
+	snapshotInterval int
+	clock            Clock
 }
 
-func (m modelManager) Upsert(model Model) (Model, error) {
+type Option func(*managerOpts)
 
-	s := reflect.ValueOf(model)
+// SetFileStorage keeps the tables in dir, backed by a write-ahead log and
+// periodic snapshots. Without it the manager is purely in memory.
+func SetFileStorage(dir string) Option {
+	return func(opts *managerOpts) {
+		opts.dir = dir
+	}
+}
 
-	if s.Kind() == reflect.Ptr {
-		return nil, fmt.Errorf("please pass the non pointer to model")
+// SetSnapshotInterval compacts the write-ahead log into a snapshot after
+// every n commits. n <= 0 disables compaction.
+func SetSnapshotInterval(n int) Option {
+	return func(opts *managerOpts) {
+		opts.snapshotInterval = n
 	}
+}
 
-	primaryKey := model.PrimaryKey()
-	tableName := model.TableName()
+// SetClock sets the clock the rows are timestamped with, the system clock
+// by default
+func SetClock(c Clock) Option {
+	return func(opts *managerOpts) {
+		opts.clock = c
+	}
+}
 
-	var data interface{}
-	var ok bool
+func NewModelManager(log log.Logger, opts ...Option) (ModelManager, error) {
 
-	if data, ok = dataBase[tableName]; !ok {
+	mo := &managerOpts{
+		snapshotInterval: defaultSnapshotInterval,
+		clock:            SystemClock(),
+	}
 
-		return nil, fmt.Errorf("invalid table name")
+	for _, opt := range opts {
+		opt(mo)
 	}
 
-	switch reflect.TypeOf(model) {
-	case reflect.TypeOf(User{}):
+	m := &modelManager{
+		l:        log,
+		clock:    mo.clock,
+		dataBase: make(map[string]map[string]Model),
+		indexes:  make(map[string]map[string]index),
+	}
 
-		users, ok := data.(map[string]User)
-		if !ok {
-			return nil, fmt.Errorf("internal error")
-		}
+	if mo.dir == "" {
+		return m, nil
+	}
 
-		nUser := reflect.ValueOf(model).Convert(reflect.TypeOf(User{})).Interface().(User)
+	return newFileModelManager(m, mo)
+}
 
-		users[primaryKey] = nUser //update the table
+// Upsert writes model if its Version still matches the stored row, and
+// returns it with the bumped version. a row never stored has version 0.
+func (m *modelManager) Upsert(model Model) (Model, error) {
 
-		dataBase[tableName] = users //update the db
+	changes, err := m.commit([]write{{model: model}})
+	if err != nil {
+		return nil, err
+	}
 
-		return nUser, nil
+	return changes[0].row, nil
+}
 
-	case reflect.TypeOf(Merchant{}):
+// Delete tombstones the row of a soft delete table, removes the row of any
+// other table, and fails with ErrAppendOnly for append-only tables
+func (m *modelManager) Delete(model Model) error {
+	_, err := m.commit([]write{{model: model, remove: true}})
+	return err
+}
 
-		merchants, ok := data.(map[string]Merchant)
-		if !ok {
-			return nil, fmt.Errorf("internal error")
-		}
+func (m *modelManager) Begin() (Tx, error) {
+	return newTx(m, m), nil
+}
 
-		nMerchant := reflect.ValueOf(model).Convert(reflect.TypeOf(Merchant{})).Interface().(Merchant)
+func (m *modelManager) Clock() Clock {
+	return m.clock
+}
 
-		merchants[primaryKey] = nMerchant
+// write is one requested change, an upsert of model or the deletion of its row
+type write struct {
+	model  Model
+	remove bool
+}
 
-		dataBase[tableName] = merchants
+// change is a validated write, ready to be applied. row is nil when the row
+// is removed altogether.
+type change struct {
+	table *table
+	key   string
+	row   Model
+}
 
-		return nMerchant, nil
+// commit applies every write or, if any of them is invalid, none of them,
+// and returns the changes as applied
+func (m *modelManager) commit(writes []write) ([]change, error) {
 
-	case reflect.TypeOf(Transaction{}):
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-		transactions, ok := data.(map[string]Transaction)
-		if !ok {
-			return nil, fmt.Errorf("internal error")
-		}
+	changes, err := m.prepare(writes)
+	if err != nil {
+		return nil, err
+	}
 
-		if _, ok := transactions[primaryKey]; ok { //transastions are append only
-			return nil, fmt.Errorf("transaction already exist with given primary key")
-		}
+	for _, c := range changes {
+		m.apply(c)
+	}
 
-		ntransaction := reflect.ValueOf(model).Convert(reflect.TypeOf(Transaction{})).Interface().(Transaction)
+	return changes, nil
+}
 
-		transactions[primaryKey] = ntransaction
-		dataBase[tableName] = transactions
+// prepare validates a batch of writes, including against each other, and
+// turns them into the changes to apply, versions bumped and timestamps set.
+// the caller holds mu.
+func (m *modelManager) prepare(writes []write) ([]change, error) {
 
-		return ntransaction, nil
+	changes := make([]change, len(writes))
+	seen := make(map[string]bool)
+	now := m.clock.Now()
 
-	case reflect.TypeOf(InterTransfer{}):
-		transfers, ok := data.(map[string]InterTransfer)
-		if !ok {
-			return nil, fmt.Errorf("internal error")
+	for i, w := range writes {
+		t, err := lookupTable(w.model)
+		if err != nil {
+			return nil, err
 		}
 
-		if _, ok := transfers[primaryKey]; ok { //transfers are append only
-			return nil, fmt.Errorf("transaction already exist with given primary key")
+		key := w.model.PrimaryKey()
+		if seen[t.name+"/"+key] {
+			return nil, fmt.Errorf("%s %s written twice in one commit", t.name, key)
 		}
+		seen[t.name+"/"+key] = true
 
-		ntransfer := reflect.ValueOf(model).Convert(reflect.TypeOf(InterTransfer{})).Interface().(InterTransfer)
-
-		transfers[primaryKey] = ntransfer
-		dataBase[tableName] = transfers
-
-		return ntransfer, nil
+		if !w.remove {
+			if _, err := m.check(w.model); err != nil {
+				return nil, err
+			}
 
-	case reflect.TypeOf(UserPaybackTransfer{}):
-
-		transfers, ok := data.(map[string]UserPaybackTransfer)
-		if !ok {
-			return nil, fmt.Errorf("internal error")
+			current := m.dataBase[t.name][key]
+			row := t.stamp(w.model, current, now)
+			changes[i] = change{t, key, t.withVersion(row, t.version(w.model)+1)}
+			continue
 		}
 
-		if _, ok := transfers[primaryKey]; ok { //transfers are append only
-			return nil, fmt.Errorf("transaction already exist with given primary key")
+		current, err := m.checkDelete(t, key)
+		if err != nil {
+			return nil, err
 		}
 
-		ntransfer := reflect.ValueOf(model).Convert(reflect.TypeOf(UserPaybackTransfer{})).Interface().(UserPaybackTransfer)
-
-		transfers[primaryKey] = ntransfer
-		dataBase[tableName] = transfers
-
-		return ntransfer, nil
-	default:
-		return nil, fmt.Errorf("invalid model type")
+		changes[i] = change{t, key, nil}
+		if t.softDelete() {
+			row := t.stamp(t.tombstone(current, now), current, now)
+			changes[i].row = t.withVersion(row, t.version(current)+1)
+		}
 	}
 
+	return changes, nil
 }
 
-func (m modelManager) GetWithPrimaryKey(model Model) (Model, bool, error) {
-
-	s := reflect.ValueOf(model)
+// check validates a write against the table it targets without applying it.
+// the caller holds mu.
+func (m *modelManager) check(model Model) (*table, error) {
 
-	if s.Kind() == reflect.Ptr {
-		return nil, false, fmt.Errorf("please pass the non pointer to model")
+	t, err := lookupTable(model)
+	if err != nil {
+		return nil, err
 	}
 
-	primaryKey := model.PrimaryKey()
-	tableName := model.TableName()
-
-	var data interface{}
-	var ok bool
+	current, found := m.dataBase[t.name][model.PrimaryKey()]
 
-	if data, ok = dataBase[tableName]; !ok {
-		m.l.ErrorD("table name does not exist", log.Fields{"table name": model.TableName()})
-		return nil, false, fmt.Errorf("invalid table name")
+	if found && t.appendOnly {
+		return nil, fmt.Errorf("%s already exist with given primary key", t.name)
 	}
 
-	switch reflect.TypeOf(model) {
-	case reflect.TypeOf(User{}):
+	if found && t.deleted(current) {
+		return nil, fmt.Errorf("%s %s has been deleted", t.name, model.PrimaryKey())
+	}
 
-		users, ok := data.(map[string]User)
-		if !ok {
-			return nil, false, fmt.Errorf("internal error")
-		}
+	var actual int64
+	if found {
+		actual = t.version(current)
+	}
 
-		if _, ok := users[primaryKey]; !ok {
-			return nil, false, nil
+	if expected := t.version(model); expected != actual {
+		return nil, &VersionConflictError{
+			Table:    t.name,
+			Key:      model.PrimaryKey(),
+			Expected: expected,
+			Actual:   actual,
 		}
+	}
 
-		return users[primaryKey], true, nil
-
-	case reflect.TypeOf(Merchant{}):
-
-		merchants, ok := data.(map[string]Merchant)
-		if !ok {
-			return nil, false, fmt.Errorf("internal error")
-		}
+	return t, nil
+}
 
-		if _, ok := merchants[primaryKey]; !ok {
-			return nil, false, nil
-		}
+// checkDelete validates the deletion of a row and returns it. the caller
+// holds mu.
+func (m *modelManager) checkDelete(t *table, key string) (Model, error) {
 
-		return merchants[primaryKey], true, nil
+	if t.appendOnly {
+		return nil, fmt.Errorf("%w: %s", ErrAppendOnly, t.name)
+	}
 
-	case reflect.TypeOf(Transaction{}):
+	current, found := m.dataBase[t.name][key]
+	if !found || t.deleted(current) {
+		return nil, fmt.Errorf("%s %s not found", t.name, key)
+	}
 
-		transactions, ok := data.(map[string]Transaction)
-		if !ok {
-			return nil, false, fmt.Errorf("internal error")
-		}
+	return current, nil
+}
 
-		if _, ok := transactions[primaryKey]; !ok {
-			return nil, false, nil
-		}
+// apply stores or removes a prepared change. the caller holds mu for writing.
+func (m *modelManager) apply(c change) {
+	if c.row == nil {
+		m.remove(c.table, c.key)
+		return
+	}
+	m.put(c.table, c.row)
+}
 
-		return transactions[primaryKey], true, nil
+// put stores a checked row. the caller holds mu for writing.
+func (m *modelManager) put(t *table, model Model) {
 
-	case reflect.TypeOf(InterTransfer{}):
-		transfers, ok := data.(map[string]InterTransfer)
-		if !ok {
-			return nil, false, fmt.Errorf("internal error")
-		}
+	rows, ok := m.dataBase[t.name]
+	if !ok {
+		rows = make(map[string]Model)
+		m.dataBase[t.name] = rows
+	}
 
-		if _, ok := transfers[primaryKey]; !ok { //transfers are append only
+	primaryKey := model.PrimaryKey()
+	old, existed := rows[primaryKey]
 
-			return nil, false, nil
+	for field, path := range t.indexes {
+		idx := m.index(t.name, field)
+		if existed {
+			idx.remove(reflect.ValueOf(old).FieldByIndex(path), primaryKey)
 		}
+		idx.add(reflect.ValueOf(model).FieldByIndex(path), primaryKey)
+	}
 
-		return transfers[primaryKey], true, nil
+	rows[primaryKey] = model
+}
 
-	case reflect.TypeOf(UserPaybackTransfer{}):
-		transfers, ok := data.(map[string]UserPaybackTransfer)
-		if !ok {
-			return nil, false, fmt.Errorf("internal error")
-		}
+// remove drops a row and its index entries. the caller holds mu for writing.
+func (m *modelManager) remove(t *table, primaryKey string) {
 
-		if _, ok := transfers[primaryKey]; !ok { //transfers are append only
-			return nil, false, nil
-		}
+	old, existed := m.dataBase[t.name][primaryKey]
+	if !existed {
+		return
+	}
 
-		return transfers[primaryKey], true, nil
-	default:
-		return nil, false, fmt.Errorf("invalid model type")
+	for field, path := range t.indexes {
+		m.index(t.name, field).remove(reflect.ValueOf(old).FieldByIndex(path), primaryKey)
 	}
+
+	delete(m.dataBase[t.name], primaryKey)
 }
 
-func (m modelManager) GetAll(model Model) ([]Model, error) {
-	s := reflect.ValueOf(model)
+func (m *modelManager) index(tableName string, field string) index {
 
-	var resp = make([]Model, 0)
+	indexes, ok := m.indexes[tableName]
+	if !ok {
+		indexes = make(map[string]index)
+		m.indexes[tableName] = indexes
+	}
 
-	if s.Kind() == reflect.Ptr {
-		return nil, fmt.Errorf("please pass the non pointer to model")
+	idx, ok := indexes[field]
+	if !ok {
+		idx = make(index)
+		indexes[field] = idx
 	}
 
-	tableName := model.TableName()
+	return idx
+}
 
-	var data interface{}
-	var ok bool
+func (m *modelManager) GetWithPrimaryKey(model Model) (Model, bool, error) {
 
-	if data, ok = dataBase[tableName]; !ok {
-		return nil, fmt.Errorf("invalid table name")
+	t, err := lookupTable(model)
+	if err != nil {
+		m.l.ErrorD("can not able to find table for model", log.Fields{"table name": model.TableName()})
+		return nil, false, err
 	}
 
-	switch reflect.TypeOf(model) {
-	case reflect.TypeOf(User{}):
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 
-		users, ok := data.(map[string]User)
-		if !ok {
-			return nil, fmt.Errorf("internal error")
-		}
+	row, ok := m.dataBase[t.name][model.PrimaryKey()]
+	if !ok || t.deleted(row) {
+		return nil, false, nil
+	}
 
-		for _, v := range users {
-			resp = append(resp, v)
-		}
+	return row, true, nil
+}
 
-		return resp, nil
+func (m *modelManager) GetAll(model Model) ([]Model, error) {
 
-	case reflect.TypeOf(Merchant{}):
+	t, err := lookupTable(model)
+	if err != nil {
+		return nil, err
+	}
 
-		merchants, ok := data.(map[string]Merchant)
-		if !ok {
-			return nil, fmt.Errorf("internal error")
-		}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var resp = make([]Model, 0, len(m.dataBase[t.name]))
 
-		for _, v := range merchants {
-			resp = append(resp, v)
+	for _, row := range m.dataBase[t.name] {
+		if !t.deleted(row) {
+			resp = append(resp, row)
 		}
+	}
 
-		return resp, nil
+	return resp, nil
+}
 
-	case reflect.TypeOf(Transaction{}):
+// Query returns the rows matching q, leaving out deleted rows unless
+// q.IncludeDeleted is set
+func (m *modelManager) Query(model Model, q Query) ([]Model, error) {
 
-		transactions, ok := data.(map[string]Transaction)
-		if !ok {
-			return nil, fmt.Errorf("internal error")
-		}
-		for _, v := range transactions {
-			resp = append(resp, v)
-		}
-		return resp, nil
+	t, err := lookupTable(model)
+	if err != nil {
+		return nil, err
+	}
 
-	case reflect.TypeOf(InterTransfer{}):
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 
-		transactions, ok := data.(map[string]InterTransfer)
-		if !ok {
-			return nil, fmt.Errorf("internal error")
-		}
-		for _, v := range transactions {
-			resp = append(resp, v)
-		}
-		return resp, nil
+	rows := m.dataBase[t.name]
 
-	case reflect.TypeOf(UserPaybackTransfer{}):
+	var candidates []Model
 
-		transactions, ok := data.(map[string]UserPaybackTransfer)
-		if !ok {
-			return nil, fmt.Errorf("internal error")
+	field, value := q.plan(t)
+	if field == "" {
+		candidates = make([]Model, 0, len(rows))
+		for _, row := range rows {
+			candidates = append(candidates, row)
 		}
-		for _, v := range transactions {
-			resp = append(resp, v)
+	} else {
+		keys := m.indexes[t.name][field][indexKey(value)]
+		candidates = make([]Model, 0, len(keys))
+		for key := range keys {
+			candidates = append(candidates, rows[key])
 		}
-		return resp, nil
+	}
 
-	default:
-		return nil, fmt.Errorf("invalid model type")
+	if !q.IncludeDeleted && t.softDelete() {
+		visible := candidates[:0]
+		for _, row := range candidates {
+			if !t.deleted(row) {
+				visible = append(visible, row)
+			}
+		}
+		candidates = visible
 	}
 
+	return q.apply(candidates)
 }
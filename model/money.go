@@ -0,0 +1,148 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// moneyScale is the number of decimal places of the minor unit
+const moneyScale = 2
+
+var (
+	ErrMoneyOverflow = errors.New("amount out of range")
+	ErrNegativeMoney = errors.New("amount can not be negative")
+)
+
+// Money is an amount in minor units, cents or paise, so that arithmetic on it
+// is exact. Add, Sub and Mul fail instead of wrapping around.
+type Money int64
+
+// ParseMoney reads a non negative decimal amount such as "12", "0.29" or
+// "1.5" exactly, rejecting anything finer than the minor unit
+func ParseMoney(str string) (Money, error) {
+
+	v, err := parseDecimal(str, moneyScale)
+	if err != nil {
+		return 0, err
+	}
+
+	return Money(v), nil
+}
+
+func (m Money) Add(o Money) (Money, error) {
+
+	if (o > 0 && m > math.MaxInt64-o) || (o < 0 && m < math.MinInt64-o) {
+		return 0, ErrMoneyOverflow
+	}
+
+	return m + o, nil
+}
+
+func (m Money) Sub(o Money) (Money, error) {
+
+	if (o < 0 && m > math.MaxInt64+o) || (o > 0 && m < math.MinInt64+o) {
+		return 0, ErrMoneyOverflow
+	}
+
+	return m - o, nil
+}
+
+func (m Money) Mul(n int64) (Money, error) {
+
+	if m == 0 || n == 0 {
+		return 0, nil
+	}
+
+	r := int64(m) * n
+	if r/n != int64(m) || (m == -1 && n == math.MinInt64) || (n == -1 && m == math.MinInt64) {
+		return 0, ErrMoneyOverflow
+	}
+
+	return Money(r), nil
+}
+
+func (m Money) IsNegative() bool {
+	return m < 0
+}
+
+// String formats the amount in major units with every minor digit, "-0.05"
+func (m Money) String() string {
+	return formatDecimal(int64(m), moneyScale)
+}
+
+// ParseBasisPoints reads a percentage such as "1.25" or "1.25%" exactly as
+// hundredths of a percent, 125
+func ParseBasisPoints(str string) (int, error) {
+
+	v, err := parseDecimal(strings.TrimSuffix(str, "%"), 2)
+	if err != nil {
+		return 0, err
+	}
+
+	if v > math.MaxInt32 {
+		return 0, ErrMoneyOverflow
+	}
+
+	return int(v), nil
+}
+
+func FormatBasisPoints(bp int) string {
+	return formatDecimal(int64(bp), 2)
+}
+
+// parseDecimal reads a non negative decimal number as an integer count of
+// 10^-scale units, failing rather than rounding when it has more decimals
+func parseDecimal(str string, scale int) (int64, error) {
+
+	if strings.HasPrefix(str, "-") {
+		return 0, ErrNegativeMoney
+	}
+
+	intPart, fracPart := str, ""
+	if i := strings.IndexByte(str, '.'); i >= 0 {
+		intPart, fracPart = str[:i], str[i+1:]
+	}
+
+	if intPart == "" && fracPart == "" {
+		return 0, fmt.Errorf("invalid amount %q", str)
+	}
+
+	fracPart = strings.TrimRight(fracPart, "0")
+	if len(fracPart) > scale {
+		return 0, fmt.Errorf("invalid amount %q: more than %d decimal places", str, scale)
+	}
+	fracPart += strings.Repeat("0", scale-len(fracPart))
+
+	var v int64
+	for _, c := range intPart + fracPart {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("invalid amount %q", str)
+		}
+
+		if v > (math.MaxInt64-int64(c-'0'))/10 {
+			return 0, ErrMoneyOverflow
+		}
+		v = v*10 + int64(c-'0')
+	}
+
+	return v, nil
+}
+
+func formatDecimal(v int64, scale int) string {
+
+	sign := ""
+	u := uint64(v)
+	if v < 0 {
+		sign = "-"
+		u = uint64(-(v + 1)) + 1 //no overflow for math.MinInt64
+	}
+
+	digits := fmt.Sprintf("%0*d", scale+1, u)
+	if scale == 0 {
+		return sign + digits
+	}
+
+	return sign + digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+}
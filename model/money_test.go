@@ -0,0 +1,146 @@
+package model
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+
+	tests := []struct {
+		in   string
+		want Money
+		err  bool
+	}{
+		{in: "0.29", want: 29},
+		{in: "1.005", err: true}, //finer than a paisa is rejected, not truncated
+		{in: "1.50", want: 150},
+		{in: "1.500", want: 150},
+		{in: "12", want: 1200},
+		{in: ".5", want: 50},
+		{in: "5.", want: 500},
+		{in: "0", want: 0},
+		{in: "92233720368547758.07", want: math.MaxInt64},
+		{in: "92233720368547758.08", err: true},
+		{in: "100000000000000000000", err: true},
+		{in: "-1", err: true},
+		{in: "", err: true},
+		{in: ".", err: true},
+		{in: "1.2.3", err: true},
+		{in: "1e3", err: true},
+		{in: " 1", err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseMoney(%q) = %d, want an error", tt.in, got)
+			}
+			continue
+		}
+
+		if err != nil || got != tt.want {
+			t.Errorf("ParseMoney(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseMoneyErrors(t *testing.T) {
+
+	if _, err := ParseMoney("-0.01"); err != ErrNegativeMoney {
+		t.Errorf("got %v, want ErrNegativeMoney", err)
+	}
+
+	if _, err := ParseMoney("92233720368547758.08"); err != ErrMoneyOverflow {
+		t.Errorf("got %v, want ErrMoneyOverflow", err)
+	}
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+
+	tests := []struct {
+		name string
+		op   func() (Money, error)
+		want Money
+		err  bool
+	}{
+		{name: "add", op: func() (Money, error) { return Money(29).Add(71) }, want: 100},
+		{name: "add overflow", op: func() (Money, error) { return Money(math.MaxInt64).Add(1) }, err: true},
+		{name: "add underflow", op: func() (Money, error) { return Money(math.MinInt64).Add(-1) }, err: true},
+		{name: "sub below zero", op: func() (Money, error) { return Money(5).Sub(7) }, want: -2},
+		{name: "sub overflow", op: func() (Money, error) { return Money(math.MaxInt64).Sub(-1) }, err: true},
+		{name: "sub underflow", op: func() (Money, error) { return Money(math.MinInt64).Sub(1) }, err: true},
+		{name: "mul", op: func() (Money, error) { return Money(300).Mul(-3) }, want: -900},
+		{name: "mul by zero", op: func() (Money, error) { return Money(math.MaxInt64).Mul(0) }, want: 0},
+		{name: "mul overflow", op: func() (Money, error) { return Money(math.MaxInt64).Mul(2) }, err: true},
+		{name: "mul large overflow", op: func() (Money, error) { return Money(1 << 40).Mul(1 << 40) }, err: true},
+		{name: "mul min by -1", op: func() (Money, error) { return Money(math.MinInt64).Mul(-1) }, err: true},
+		{name: "mul -1 by min", op: func() (Money, error) { return Money(-1).Mul(math.MinInt64) }, err: true},
+		{name: "mul min by 1", op: func() (Money, error) { return Money(math.MinInt64).Mul(1) }, want: math.MinInt64},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.op()
+		if tt.err {
+			if err != ErrMoneyOverflow {
+				t.Errorf("%s = %d, %v, want ErrMoneyOverflow", tt.name, got, err)
+			}
+			continue
+		}
+
+		if err != nil || got != tt.want {
+			t.Errorf("%s = %d, %v, want %d", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+
+	tests := []struct {
+		in   Money
+		want string
+	}{
+		{0, "0.00"},
+		{29, "0.29"},
+		{-5, "-0.05"},
+		{150, "1.50"},
+		{math.MaxInt64, "92233720368547758.07"},
+		{math.MinInt64, "-92233720368547758.08"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("Money(%d).String() = %q, want %q", int64(tt.in), got, tt.want)
+		}
+	}
+}
+
+func TestParseBasisPoints(t *testing.T) {
+
+	tests := []struct {
+		in   string
+		want int
+		err  bool
+	}{
+		{in: "1.25", want: 125},
+		{in: "1.25%", want: 125},
+		{in: "36%", want: 3600},
+		{in: "0.005", err: true},
+		{in: "21474836.48", err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseBasisPoints(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseBasisPoints(%q) = %d, want an error", tt.in, got)
+			}
+			continue
+		}
+
+		if err != nil || got != tt.want {
+			t.Errorf("ParseBasisPoints(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
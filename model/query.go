@@ -0,0 +1,291 @@
+package model
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+type Operator string
+
+const (
+	Eq  = Operator("=")
+	Lt  = Operator("<")
+	Lte = Operator("<=")
+	Gt  = Operator(">")
+	Gte = Operator(">=")
+)
+
+type Filter struct {
+	Field string
+	Op    Operator
+	Value interface{}
+}
+
+// Query selects rows of one table. every filter has to match, rows come back
+// ordered by SortBy (primary key when empty) and are then paged by Offset
+// and Limit, a Limit of 0 meaning no limit. soft deleted rows are left out
+// unless IncludeDeleted is set.
+type Query struct {
+	Filters        []Filter
+	SortBy         string
+	Desc           bool
+	Limit          int
+	Offset         int
+	IncludeDeleted bool
+}
+
+func NewQuery() Query {
+	return Query{}
+}
+
+func (q Query) Where(field string, op Operator, value interface{}) Query {
+	q.Filters = append(append([]Filter{}, q.Filters...), Filter{field, op, value})
+	return q
+}
+
+func (q Query) OrderBy(field string, desc bool) Query {
+	q.SortBy = field
+	q.Desc = desc
+	return q
+}
+
+func (q Query) Page(limit int, offset int) Query {
+	q.Limit = limit
+	q.Offset = offset
+	return q
+}
+
+func (q Query) WithDeleted() Query {
+	q.IncludeDeleted = true
+	return q
+}
+
+// index maps the value of one field to the primary keys holding it
+type index map[string]map[string]bool
+
+func indexKey(v reflect.Value) string {
+	return fmt.Sprint(v.Interface())
+}
+
+func (i index) add(value reflect.Value, primaryKey string) {
+
+	key := indexKey(value)
+
+	keys, ok := i[key]
+	if !ok {
+		keys = make(map[string]bool)
+		i[key] = keys
+	}
+
+	keys[primaryKey] = true
+}
+
+func (i index) remove(value reflect.Value, primaryKey string) {
+
+	key := indexKey(value)
+
+	delete(i[key], primaryKey)
+	if len(i[key]) == 0 {
+		delete(i, key)
+	}
+}
+
+// plan returns the field of the first equality filter backed by an index,
+// with the value converted to the type of the field as compare does, or ""
+// when the query has to scan the table. a value that does not convert is left
+// to the scan to report.
+func (q Query) plan(t *table) (string, reflect.Value) {
+
+	for _, f := range q.Filters {
+		if f.Op != Eq {
+			continue
+		}
+
+		path, ok := t.indexes[f.Field]
+		if !ok {
+			continue
+		}
+
+		rv := reflect.ValueOf(f.Value)
+		if !rv.IsValid() {
+			continue
+		}
+
+		typ := t.typ.FieldByIndex(path).Type
+		if rv.Type() != typ {
+			if !rv.Type().ConvertibleTo(typ) {
+				continue
+			}
+			rv = rv.Convert(typ)
+		}
+
+		return f.Field, rv
+	}
+
+	return "", reflect.Value{}
+}
+
+// match reports whether row satisfies every filter of the query
+func (q Query) match(row Model) (bool, error) {
+
+	v := reflect.ValueOf(row)
+
+	for _, f := range q.Filters {
+		field := v.FieldByName(f.Field)
+		if !field.IsValid() {
+			return false, fmt.Errorf("invalid field %s for table %s", f.Field, row.TableName())
+		}
+
+		c, err := compare(field, f.Value)
+		if err != nil {
+			return false, fmt.Errorf("field %s: %v", f.Field, err)
+		}
+
+		var ok bool
+		switch f.Op {
+		case Eq:
+			ok = c == 0
+		case Lt:
+			ok = c < 0
+		case Lte:
+			ok = c <= 0
+		case Gt:
+			ok = c > 0
+		case Gte:
+			ok = c >= 0
+		default:
+			return false, fmt.Errorf("invalid operator %s", f.Op)
+		}
+
+		if !ok {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// apply filters, sorts and pages rows
+func (q Query) apply(rows []Model) ([]Model, error) {
+
+	var resp = make([]Model, 0, len(rows))
+
+	for _, row := range rows {
+		ok, err := q.match(row)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			resp = append(resp, row)
+		}
+	}
+
+	var sortErr error
+
+	sort.SliceStable(resp, func(i, j int) bool {
+
+		if q.SortBy == "" {
+			return resp[i].PrimaryKey() < resp[j].PrimaryKey()
+		}
+
+		a := reflect.ValueOf(resp[i]).FieldByName(q.SortBy)
+		b := reflect.ValueOf(resp[j]).FieldByName(q.SortBy)
+		if !a.IsValid() || !b.IsValid() {
+			sortErr = fmt.Errorf("invalid sort field %s", q.SortBy)
+			return false
+		}
+
+		c, err := compare(a, b.Interface())
+		if err != nil {
+			sortErr = err
+			return false
+		}
+
+		if c == 0 {
+			return resp[i].PrimaryKey() < resp[j].PrimaryKey()
+		}
+
+		return (c < 0) != q.Desc
+	})
+
+	if sortErr != nil {
+		return nil, sortErr
+	}
+
+	if q.Offset > 0 {
+		if q.Offset >= len(resp) {
+			return resp[:0], nil
+		}
+		resp = resp[q.Offset:]
+	}
+
+	if q.Limit > 0 && q.Limit < len(resp) {
+		resp = resp[:q.Limit]
+	}
+
+	return resp, nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// compare orders a field against a filter value of the same (or a
+// convertible) type, returning -1, 0 or 1
+func compare(field reflect.Value, value interface{}) (int, error) {
+
+	rv := reflect.ValueOf(value)
+	if !rv.IsValid() {
+		return 0, fmt.Errorf("nil value")
+	}
+
+	if rv.Type() != field.Type() {
+		if !rv.Type().ConvertibleTo(field.Type()) {
+			return 0, fmt.Errorf("can not compare %s with %s", field.Type(), rv.Type())
+		}
+		rv = rv.Convert(field.Type())
+	}
+
+	if field.Type() == timeType {
+		a, b := field.Interface().(time.Time), rv.Interface().(time.Time)
+		switch {
+		case a.Before(b):
+			return -1, nil
+		case a.After(b):
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return compareOrdered(field.Int() < rv.Int(), field.Int() > rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return compareOrdered(field.Uint() < rv.Uint(), field.Uint() > rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return compareOrdered(field.Float() < rv.Float(), field.Float() > rv.Float()), nil
+	case reflect.String:
+		return compareOrdered(field.String() < rv.String(), field.String() > rv.String()), nil
+	case reflect.Bool:
+		if field.Bool() == rv.Bool() {
+			return 0, nil
+		}
+		return compareOrdered(!field.Bool(), field.Bool()), nil
+	case reflect.Array:
+		a, b := fmt.Sprint(field.Interface()), fmt.Sprint(rv.Interface())
+		return compareOrdered(a < b, a > b), nil
+	}
+
+	return 0, fmt.Errorf("can not compare values of kind %s", field.Kind())
+}
+
+func compareOrdered(less bool, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,84 @@
+package model
+
+import (
+	"io/ioutil"
+	"pay-later/integration/log"
+	"testing"
+)
+
+type indexedRow struct {
+	Name   string
+	Amount Money
+}
+
+func (m indexedRow) TableName() string {
+	return "indexedrow"
+}
+
+func (m indexedRow) PrimaryKey() string {
+	return m.Name
+}
+
+type scannedRow struct {
+	Name   string
+	Amount Money
+}
+
+func (m scannedRow) TableName() string {
+	return "scannedrow"
+}
+
+func (m scannedRow) PrimaryKey() string {
+	return m.Name
+}
+
+func init() {
+	RegisterTable(indexedRow{}, Index("Amount"))
+	RegisterTable(scannedRow{})
+}
+
+func TestQueryIndexConvertsLikeScan(t *testing.T) {
+
+	m, err := NewModelManager(log.NewLogger(log.SetOutput(ioutil.Discard)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a", "b"} {
+		if _, err := m.Upsert(indexedRow{Name: name, Amount: 29}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Upsert(scannedRow{Name: name, Amount: 29}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		value interface{}
+		want  int
+		err   bool
+	}{
+		{name: "same type", value: Money(29), want: 2},
+		{name: "convertible type", value: 29, want: 2}, //Money prints 0.29, an int 29
+		{name: "no match", value: int64(30), want: 0},
+		{name: "not convertible", value: "0.29", err: true},
+		{name: "nil", value: nil, err: true},
+	}
+
+	for _, tt := range tests {
+		for _, row := range []Model{indexedRow{}, scannedRow{}} {
+			rows, err := m.Query(row, NewQuery().Where("Amount", Eq, tt.value))
+			if tt.err {
+				if err == nil {
+					t.Errorf("%s: %s query got %d rows, want an error", tt.name, row.TableName(), len(rows))
+				}
+				continue
+			}
+
+			if err != nil || len(rows) != tt.want {
+				t.Errorf("%s: %s query got %d rows, %v, want %d", tt.name, row.TableName(), len(rows), err, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package model
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+)
+
+// RoundingPolicy decides how an amount that falls between two minor units,
+// such as a percentage of a payment, is rounded to one of them
+type RoundingPolicy string
+
+const (
+	RoundHalfUp   = RoundingPolicy("half-up")   //nearest, halves away from zero
+	RoundHalfEven = RoundingPolicy("half-even") //nearest, halves to the even unit (banker's rounding)
+	RoundFloor    = RoundingPolicy("floor")     //towards negative infinity
+	RoundCeiling  = RoundingPolicy("ceiling")   //towards positive infinity
+)
+
+// rateScale is the denominator of a rate given in basis points
+const rateScale = 10000
+
+var (
+	defaultRoundingMu     sync.RWMutex
+	defaultRoundingPolicy = RoundFloor
+)
+
+func ParseRoundingPolicy(str string) (RoundingPolicy, error) {
+
+	p := RoundingPolicy(str)
+	if err := p.Validate(); err != nil {
+		return "", err
+	}
+
+	return p, nil
+}
+
+func (p RoundingPolicy) Validate() error {
+
+	switch p {
+	case RoundHalfUp, RoundHalfEven, RoundFloor, RoundCeiling:
+		return nil
+	}
+
+	return fmt.Errorf("invalid rounding policy %q, use half-up, half-even, floor or ceiling", string(p))
+}
+
+// DefaultRoundingPolicy is used for every merchant without a policy of its
+// own, floor unless changed with SetDefaultRoundingPolicy
+func DefaultRoundingPolicy() RoundingPolicy {
+
+	defaultRoundingMu.RLock()
+	defer defaultRoundingMu.RUnlock()
+
+	return defaultRoundingPolicy
+}
+
+func SetDefaultRoundingPolicy(p RoundingPolicy) error {
+
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	defaultRoundingMu.Lock()
+	defaultRoundingPolicy = p
+	defaultRoundingMu.Unlock()
+
+	return nil
+}
+
+// ApplyRate is amount times rate, in basis points, rounded to the minor unit
+// by the policy. the residual is how much the rounded amount is over the
+// exact one, in 1/10000 of the minor unit, so that the two can be reconciled.
+func (p RoundingPolicy) ApplyRate(amount Money, rate int) (Money, int64, error) {
+
+	if err := p.Validate(); err != nil {
+		return 0, 0, err
+	}
+
+	exact, err := amount.Mul(int64(rate))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	n := big.NewInt(int64(exact))
+	q := p.divide(n, big.NewInt(rateScale))
+
+	//|q| is at most |exact| / rateScale + 1, the residual can not overflow
+	return Money(q.Int64()), q.Int64()*rateScale - int64(exact), nil
+}
+
+// Prorate is the share of amount that part is of whole, amount * part /
+// whole rounded to the minor unit by the policy, for a positive whole
+func (p RoundingPolicy) Prorate(amount Money, part Money, whole Money) (Money, error) {
+
+	if err := p.Validate(); err != nil {
+		return 0, err
+	}
+
+	if whole <= 0 {
+		return 0, fmt.Errorf("can not prorate over %s", whole)
+	}
+
+	n := new(big.Int).Mul(big.NewInt(int64(amount)), big.NewInt(int64(part)))
+	q := p.divide(n, big.NewInt(int64(whole)))
+	if !q.IsInt64() {
+		return 0, ErrMoneyOverflow
+	}
+
+	return Money(q.Int64()), nil
+}
+
+// divide is n / d rounded by the policy, for a positive d
+func (p RoundingPolicy) divide(n *big.Int, d *big.Int) *big.Int {
+
+	q, r := new(big.Int).QuoRem(n, d, new(big.Int))
+
+	sign := int64(n.Sign())
+	if sign == 0 {
+		return q
+	}
+
+	//twice the distance from q, compared against d to find halves
+	half := new(big.Int).Abs(r)
+	half.Lsh(half, 1)
+
+	switch p {
+	case RoundFloor:
+		if r.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		}
+	case RoundCeiling:
+		if r.Sign() > 0 {
+			q.Add(q, big.NewInt(1))
+		}
+	case RoundHalfUp:
+		if half.Cmp(d) >= 0 {
+			q.Add(q, big.NewInt(sign))
+		}
+	case RoundHalfEven:
+		if c := half.Cmp(d); c > 0 || (c == 0 && q.Bit(0) != 0) {
+			q.Add(q, big.NewInt(sign))
+		}
+	}
+
+	return q
+}
@@ -0,0 +1,111 @@
+package model
+
+import (
+	"math"
+	"math/big"
+	"testing"
+)
+
+var policies = []RoundingPolicy{RoundFloor, RoundCeiling, RoundHalfUp, RoundHalfEven}
+
+func TestDivide(t *testing.T) {
+
+	//n / 10 under floor, ceiling, half-up and half-even
+	tests := []struct {
+		n    int64
+		want [4]int64
+	}{
+		{15, [4]int64{1, 2, 2, 2}},
+		{-15, [4]int64{-2, -1, -2, -2}},
+		{25, [4]int64{2, 3, 3, 2}},
+		{-25, [4]int64{-3, -2, -3, -2}},
+		{14, [4]int64{1, 2, 1, 1}},
+		{-14, [4]int64{-2, -1, -1, -1}},
+		{16, [4]int64{1, 2, 2, 2}},
+		{-16, [4]int64{-2, -1, -2, -2}},
+		{20, [4]int64{2, 2, 2, 2}},
+		{-20, [4]int64{-2, -2, -2, -2}},
+		{0, [4]int64{0, 0, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		for i, p := range policies {
+			if got := p.divide(big.NewInt(tt.n), big.NewInt(10)).Int64(); got != tt.want[i] {
+				t.Errorf("%s: %d / 10 = %d, want %d", p, tt.n, got, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestApplyRate(t *testing.T) {
+
+	tests := []struct {
+		amount   Money
+		rate     int
+		policy   RoundingPolicy
+		want     Money
+		residual int64
+	}{
+		//10.01 at 1.5% is 0.15015
+		{1001, 150, RoundFloor, 15, -150},
+		{1001, 150, RoundCeiling, 16, 9850},
+		{1001, 150, RoundHalfUp, 15, -150},
+		{1001, 150, RoundHalfEven, 15, -150},
+
+		//ties, 0.005, 0.015 and 0.025
+		{50, 100, RoundHalfUp, 1, 5000},
+		{50, 100, RoundHalfEven, 0, -5000},
+		{150, 100, RoundHalfUp, 2, 5000},
+		{150, 100, RoundHalfEven, 2, 5000},
+		{250, 100, RoundHalfUp, 3, 5000},
+		{250, 100, RoundHalfEven, 2, -5000},
+		{250, 100, RoundFloor, 2, -5000},
+		{250, 100, RoundCeiling, 3, 5000},
+
+		//exact
+		{10000, 150, RoundCeiling, 150, 0},
+		{0, 150, RoundCeiling, 0, 0},
+	}
+
+	for _, tt := range tests {
+		got, residual, err := tt.policy.ApplyRate(tt.amount, tt.rate)
+		if err != nil || got != tt.want || residual != tt.residual {
+			t.Errorf("%s: %s at %d bp = %s, residual %d, %v, want %s, residual %d", tt.policy, tt.amount, tt.rate, got, residual, err, tt.want, tt.residual)
+		}
+	}
+
+	if _, _, err := RoundFloor.ApplyRate(math.MaxInt64, 150); err != ErrMoneyOverflow {
+		t.Errorf("got %v, want ErrMoneyOverflow", err)
+	}
+
+	if _, _, err := RoundingPolicy("nearest").ApplyRate(100, 150); err == nil {
+		t.Error("applied a rate with an invalid policy")
+	}
+}
+
+func TestProrate(t *testing.T) {
+
+	//151 * 24 / 100 is 36.24, 151 * 50 / 100 is 75.5
+	tests := []struct {
+		part Money
+		want [4]Money
+	}{
+		{24, [4]Money{36, 37, 36, 36}},
+		{50, [4]Money{75, 76, 76, 76}},
+		{100, [4]Money{151, 151, 151, 151}},
+		{0, [4]Money{0, 0, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		for i, p := range policies {
+			got, err := p.Prorate(151, tt.part, 100)
+			if err != nil || got != tt.want[i] {
+				t.Errorf("%s: 151 * %s / 100 = %s, %v, want %s", p, tt.part, got, err, tt.want[i])
+			}
+		}
+	}
+
+	if _, err := RoundFloor.Prorate(151, 24, 0); err == nil {
+		t.Error("prorated over a zero whole")
+	}
+}
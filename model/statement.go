@@ -0,0 +1,42 @@
+package model
+
+import (
+	"time"
+)
+
+func init() {
+	RegisterTable(Statement{}, AppendOnly(), Index("UserName"))
+}
+
+// Statement is the bill of a user for one billing cycle, closed from the
+// journal lines of their receivable posted from CycleStart up to, not
+// including, CycleEnd. Month is the month the cycle starts in, 2006-01.
+type Statement struct {
+	UserName       string
+	Month          string
+	Currency       Currency
+	CycleStart     time.Time
+	CycleEnd       time.Time
+	OpeningBalance Money
+	Purchases      Money //charged by transfers to merchants
+	Refunds        Money //of purchases, a credit
+	Paybacks       Money //paid back by the user, a credit
+	Fees           Money
+	Interest       Money     //on overdue balances, posted when the cycle closes
+	Adjustments    Money     //anything else, debit positive
+	ClosingBalance Money     //the dues the statement closed with
+	MinimumDue     Money     //least to pay by the due date to not be late
+	DueDate        time.Time //last day the closing balance can be paid on
+	Version        int64
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	PostedAt       time.Time
+}
+
+func (m Statement) TableName() string {
+	return "statement"
+}
+
+func (m Statement) PrimaryKey() string {
+	return m.UserName + "/" + m.Month
+}
@@ -0,0 +1,218 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// registry of every table the model managers can store, keyed by table name
+var registry = make(map[string]*table)
+
+type table struct {
+	name         string
+	typ          reflect.Type
+	appendOnly   bool
+	versionField []int //index of the Version field, nil when the model has none
+	deletedField []int //index of the DeletedAt field of soft delete tables
+	createdField []int //indexes of the time.Time fields stamped on write, if any
+	updatedField []int
+	postedField  []int
+	indexes      map[string][]int
+}
+
+type TableOption func(*table)
+
+// AppendOnly rejects any write to a primary key that already exists, which
+// is how ledger tables keep their history immutable.
+func AppendOnly() TableOption {
+	return func(t *table) {
+		t.appendOnly = true
+	}
+}
+
+// SoftDelete makes Delete tombstone rows by stamping their DeletedAt field,
+// which has to be a time.Time, instead of removing them. tombstoned rows are
+// hidden from reads and their primary key can not be reused.
+func SoftDelete() TableOption {
+	return func(t *table) {
+		f, ok := t.typ.FieldByName("DeletedAt")
+		if !ok || f.Type != timeType {
+			panic(fmt.Sprintf("model: soft delete table %s needs a DeletedAt time.Time field", t.name))
+		}
+		t.deletedField = f.Index
+	}
+}
+
+// Index declares secondary indexes on fields of the model, used by Query for
+// equality filters on them.
+func Index(fields ...string) TableOption {
+	return func(t *table) {
+		for _, field := range fields {
+			f, ok := t.typ.FieldByName(field)
+			if !ok {
+				panic(fmt.Sprintf("model: can not index unknown field %s of table %s", field, t.name))
+			}
+			t.indexes[field] = f.Index
+		}
+	}
+}
+
+// RegisterTable declares the table for a model type. it is meant to be
+// called once per model from an init function, and panics on a duplicate
+// or pointer registration since both are programming errors.
+func RegisterTable(model Model, opts ...TableOption) {
+
+	typ := reflect.TypeOf(model)
+	if typ.Kind() == reflect.Ptr {
+		panic(fmt.Sprintf("model: register %s as a non pointer", typ))
+	}
+
+	name := model.TableName()
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("model: table %s registered twice", name))
+	}
+
+	t := &table{
+		name:    name,
+		typ:     typ,
+		indexes: make(map[string][]int),
+	}
+
+	if f, ok := typ.FieldByName("Version"); ok && f.Type.Kind() == reflect.Int64 {
+		t.versionField = f.Index
+	}
+
+	t.createdField = timeField(typ, "CreatedAt")
+	t.updatedField = timeField(typ, "UpdatedAt")
+	t.postedField = timeField(typ, "PostedAt")
+
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	registry[name] = t
+}
+
+func timeField(typ reflect.Type, name string) []int {
+
+	f, ok := typ.FieldByName(name)
+	if !ok || f.Type != timeType {
+		return nil
+	}
+
+	return f.Index
+}
+
+// lookupTable finds the registered table for model and checks that model is
+// the type the table was registered with
+func lookupTable(model Model) (*table, error) {
+
+	if model == nil {
+		return nil, fmt.Errorf("nil model")
+	}
+
+	if reflect.ValueOf(model).Kind() == reflect.Ptr {
+		return nil, fmt.Errorf("please pass the non pointer to model")
+	}
+
+	t, ok := registry[model.TableName()]
+	if !ok {
+		return nil, fmt.Errorf("invalid table name")
+	}
+
+	if reflect.TypeOf(model) != t.typ {
+		return nil, fmt.Errorf("invalid model type")
+	}
+
+	return t, nil
+}
+
+// version is the row version carried by model, 0 for a row never stored
+func (t *table) version(model Model) int64 {
+
+	if t.versionField == nil {
+		return 0
+	}
+
+	return reflect.ValueOf(model).FieldByIndex(t.versionField).Int()
+}
+
+// withVersion returns a copy of model carrying version v
+func (t *table) withVersion(model Model, v int64) Model {
+
+	if t.versionField == nil {
+		return model
+	}
+
+	row := reflect.New(t.typ).Elem()
+	row.Set(reflect.ValueOf(model))
+	row.FieldByIndex(t.versionField).SetInt(v)
+
+	return row.Interface().(Model)
+}
+
+func (t *table) softDelete() bool {
+	return t.deletedField != nil
+}
+
+// deleted reports whether row is a tombstone
+func (t *table) deleted(row Model) bool {
+
+	if t.deletedField == nil {
+		return false
+	}
+
+	return !reflect.ValueOf(row).FieldByIndex(t.deletedField).Interface().(time.Time).IsZero()
+}
+
+// tombstone returns a copy of row deleted at the given time
+func (t *table) tombstone(row Model, at time.Time) Model {
+
+	v := reflect.New(t.typ).Elem()
+	v.Set(reflect.ValueOf(row))
+	v.FieldByIndex(t.deletedField).Set(reflect.ValueOf(at))
+
+	return v.Interface().(Model)
+}
+
+// stamp returns a copy of row with its timestamps set for a write at now.
+// CreatedAt is kept from the stored row, if any, UpdatedAt is always now and
+// PostedAt is only set when the caller did not post the row at a given time.
+func (t *table) stamp(row Model, current Model, now time.Time) Model {
+
+	v := reflect.New(t.typ).Elem()
+	v.Set(reflect.ValueOf(row))
+
+	if t.createdField != nil {
+		created := now
+		if current != nil {
+			created = reflect.ValueOf(current).FieldByIndex(t.createdField).Interface().(time.Time)
+		}
+		v.FieldByIndex(t.createdField).Set(reflect.ValueOf(created))
+	}
+
+	if t.updatedField != nil {
+		v.FieldByIndex(t.updatedField).Set(reflect.ValueOf(now))
+	}
+
+	if t.postedField != nil {
+		if posted := v.FieldByIndex(t.postedField); posted.Interface().(time.Time).IsZero() {
+			posted.Set(reflect.ValueOf(now))
+		}
+	}
+
+	return v.Interface().(Model)
+}
+
+// decode builds a row of this table from its json encoding
+func (t *table) decode(data []byte) (Model, error) {
+
+	v := reflect.New(t.typ)
+	if err := json.Unmarshal(data, v.Interface()); err != nil {
+		return nil, err
+	}
+
+	return v.Elem().Interface().(Model), nil
+}
@@ -1,6 +1,8 @@
 package model
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 )
 
@@ -10,17 +12,35 @@ const (
 	USER_MERCHANT_TRANSFER    = TransactionType("user-merchant")
 	USER_PAYBACK_TRANSFER     = TransactionType("user-payback")
 	MERCHANT_DISCOUNT_CREDIT  = TransactionType("merchant-discount")
+	LEDGER_ADJUSTMENT         = TransactionType("ledger-adjustment")
+	LATE_FEE                  = TransactionType("late-fee")
+	INTEREST                  = TransactionType("interest")
+	CREDIT_REFUND             = TransactionType("credit-refund")
+	MERCHANT_REFUND           = TransactionType("merchant-refund")
+	DISCOUNT_CLAWBACK         = TransactionType("discount-clawback")
+	DISPUTE_CREDIT            = TransactionType("dispute-credit")
+	DISPUTE_REVERSAL          = TransactionType("dispute-reversal")
 	CLEARING_ACCOUNT_NAME     = "clearing-account"
 	USER_PAYBACK_ACCOUNT_NAME = "external-account"
+	LENDER_ACCOUNT_NAME       = "lender-revenue"
 )
 
+func init() {
+	RegisterTable(Transaction{}, AppendOnly(), Index("SourceName", "DestinationName", "Type", "TransferID"))
+}
+
 type Transaction struct {
 	ID              uuid.UUID
 	TransferID      uuid.UUID
 	Type            TransactionType
 	SourceName      string
 	DestinationName string
-	Amount          int //can be stored as cents
+	Amount          Money
+	Currency        Currency
+	Version         int64
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	PostedAt        time.Time //when the transaction hit the ledger, stamped unless given
 }
 
 func (m Transaction) TableName() string {
@@ -1,15 +1,37 @@
 package model
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 )
 
+func init() {
+	RegisterTable(InterTransfer{}, AppendOnly(), Index("UserName", "MerchantName"))
+	RegisterTable(UserPaybackTransfer{}, AppendOnly(), Index("UserName"))
+	RegisterTable(LateFeeTransfer{}, AppendOnly(), Index("UserName", "Month"))
+	RegisterTable(InterestTransfer{}, AppendOnly(), Index("UserName", "Month"))
+	RegisterTable(CreditRefundTransfer{}, AppendOnly(), Index("UserName"))
+	RegisterTable(RefundTransfer{}, AppendOnly(), Index("TransferID", "UserName", "MerchantName"))
+}
+
 type InterTransfer struct {
-	ID             uuid.UUID
-	UserName       string
-	MerchantName   string
-	Amount         int
-	DiscountAmount int //will be store as paise, instead of rupees
+	ID               uuid.UUID
+	UserName         string
+	MerchantName     string
+	Amount           Money //charged to the user, MerchantAmount converted at FXRate
+	Currency         Currency
+	MerchantAmount   Money //the sale, in the merchant's currency
+	MerchantCurrency Currency
+	FXRate           int64          //rate of the conversion, 0 when both currencies are the same
+	DiscountAmount   Money          //in the merchant's currency
+	DiscountRate     int            //basis points the discount was taken at
+	Rounding         RoundingPolicy //policy the discount was rounded by
+	Residual         int64          //rounded minus exact discount, in 1/10000 of the minor unit
+	Version          int64
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	PostedAt         time.Time
 }
 
 func (m InterTransfer) TableName() string {
@@ -20,10 +42,26 @@ func (m InterTransfer) PrimaryKey() string {
 	return m.ID.String()
 }
 
+// SaleAmount is the sale in the merchant's currency. transfers written before
+// currencies existed only have Amount, which was the sale.
+func (m InterTransfer) SaleAmount() Money {
+
+	if m.MerchantCurrency == "" {
+		return m.Amount
+	}
+
+	return m.MerchantAmount
+}
+
 type UserPaybackTransfer struct {
-	ID       uuid.UUID
-	UserName string
-	Amount   int
+	ID        uuid.UUID
+	UserName  string
+	Amount    Money
+	Currency  Currency
+	Version   int64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	PostedAt  time.Time
 }
 
 func (m UserPaybackTransfer) TableName() string {
@@ -33,3 +71,101 @@ func (m UserPaybackTransfer) TableName() string {
 func (m UserPaybackTransfer) PrimaryKey() string {
 	return m.ID.String()
 }
+
+// LateFeeTransfer is the late fee charged to a user for the statement of
+// Month left unpaid past its grace period
+type LateFeeTransfer struct {
+	ID        uuid.UUID
+	UserName  string
+	Month     string
+	Amount    Money
+	Currency  Currency
+	Version   int64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	PostedAt  time.Time
+}
+
+func (m LateFeeTransfer) TableName() string {
+	return "latefeetransfer"
+}
+
+func (m LateFeeTransfer) PrimaryKey() string {
+	return m.ID.String()
+}
+
+// InterestTransfer is the interest charged to a user on overdue balances
+// over the cycle of the statement of Month, at the policy it was accrued by
+type InterestTransfer struct {
+	ID          uuid.UUID
+	UserName    string
+	Month       string
+	Amount      Money
+	Currency    Currency
+	APR         int
+	DayCount    DayCount
+	Compounding Compounding
+	Version     int64
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	PostedAt    time.Time
+}
+
+func (m InterestTransfer) TableName() string {
+	return "interesttransfer"
+}
+
+func (m InterestTransfer) PrimaryKey() string {
+	return m.ID.String()
+}
+
+// CreditRefundTransfer sends part of the credit balance of a user, what they
+// overpaid, back to where it came from. PaybackID is the last overpayment
+// before it, if any.
+type CreditRefundTransfer struct {
+	ID        uuid.UUID
+	UserName  string
+	PaybackID uuid.UUID
+	Amount    Money
+	Currency  Currency
+	Version   int64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	PostedAt  time.Time
+}
+
+func (m CreditRefundTransfer) TableName() string {
+	return "creditrefundtransfer"
+}
+
+func (m CreditRefundTransfer) PrimaryKey() string {
+	return m.ID.String()
+}
+
+// RefundTransfer reverses all or part of the inter transfer TransferID. the
+// merchant gives back MerchantAmount of the sale less the DiscountAmount
+// clawed back from the lender, and the user is credited Amount.
+type RefundTransfer struct {
+	ID               uuid.UUID
+	TransferID       uuid.UUID
+	UserName         string
+	MerchantName     string
+	Amount           Money //credited to the user, in their currency
+	Currency         Currency
+	MerchantAmount   Money //of the sale refunded, in the merchant's currency
+	MerchantCurrency Currency
+	DiscountAmount   Money     //clawed back, in the merchant's currency
+	DisputeID        uuid.UUID //the dispute it is the chargeback of, if any
+	Version          int64
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	PostedAt         time.Time
+}
+
+func (m RefundTransfer) TableName() string {
+	return "refundtransfer"
+}
+
+func (m RefundTransfer) PrimaryKey() string {
+	return m.ID.String()
+}
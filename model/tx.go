@@ -0,0 +1,260 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrTxDone = errors.New("transaction has already been committed or rolled back")
+
+// Tx is a unit of work over a ModelManager. writes are staged and only
+// applied, all of them or none, on Commit. reads see the staged writes, which
+// keep the version they were read at until the commit bumps it.
+// a Tx belongs to one goroutine and is not safe for concurrent use.
+type Tx interface {
+	ModelManager
+	Commit() error
+	Rollback() error
+}
+
+type committer interface {
+	commit([]write) ([]change, error)
+}
+
+type tx struct {
+	base   *modelManager
+	target committer
+	writes []write
+	staged map[string]map[string]int //table -> primary key -> index in writes
+	done   bool
+}
+
+func newTx(base *modelManager, target committer) *tx {
+	return &tx{
+		base:   base,
+		target: target,
+		staged: make(map[string]map[string]int),
+	}
+}
+
+// lookupStaged returns the staged write for the primary key of model
+func (t *tx) lookupStaged(tbl *table, model Model) (write, bool) {
+
+	i, ok := t.staged[tbl.name][model.PrimaryKey()]
+	if !ok {
+		return write{}, false
+	}
+
+	return t.writes[i], true
+}
+
+func (t *tx) stage(tbl *table, w write) {
+
+	rows, ok := t.staged[tbl.name]
+	if !ok {
+		rows = make(map[string]int)
+		t.staged[tbl.name] = rows
+	}
+
+	if i, ok := rows[w.model.PrimaryKey()]; ok {
+		t.writes[i] = w
+		return
+	}
+
+	rows[w.model.PrimaryKey()] = len(t.writes)
+	t.writes = append(t.writes, w)
+}
+
+func (t *tx) Upsert(model Model) (Model, error) {
+
+	if t.done {
+		return nil, ErrTxDone
+	}
+
+	tbl, err := lookupTable(model)
+	if err != nil {
+		return nil, err
+	}
+
+	if staged, ok := t.lookupStaged(tbl, model); ok {
+		if staged.remove {
+			return nil, fmt.Errorf("%s %s has been deleted in this transaction", tbl.name, model.PrimaryKey())
+		}
+
+		if tbl.appendOnly {
+			return nil, fmt.Errorf("%s already exist with given primary key", tbl.name)
+		}
+
+		if expected, actual := tbl.version(model), tbl.version(staged.model); expected != actual {
+			return nil, &VersionConflictError{
+				Table:    tbl.name,
+				Key:      model.PrimaryKey(),
+				Expected: expected,
+				Actual:   actual,
+			}
+		}
+	} else {
+		t.base.mu.RLock()
+		_, err = t.base.check(model)
+		t.base.mu.RUnlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	t.stage(tbl, write{model: model})
+
+	return model, nil
+}
+
+func (t *tx) Delete(model Model) error {
+
+	if t.done {
+		return ErrTxDone
+	}
+
+	tbl, err := lookupTable(model)
+	if err != nil {
+		return err
+	}
+
+	if staged, ok := t.lookupStaged(tbl, model); ok {
+		if staged.remove {
+			return fmt.Errorf("%s %s not found", tbl.name, model.PrimaryKey())
+		}
+		return fmt.Errorf("can not delete %s %s written in this transaction", tbl.name, model.PrimaryKey())
+	}
+
+	t.base.mu.RLock()
+	_, err = t.base.checkDelete(tbl, model.PrimaryKey())
+	t.base.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	t.stage(tbl, write{model: model, remove: true})
+
+	return nil
+}
+
+func (t *tx) GetWithPrimaryKey(model Model) (Model, bool, error) {
+
+	if t.done {
+		return nil, false, ErrTxDone
+	}
+
+	tbl, err := lookupTable(model)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if staged, ok := t.lookupStaged(tbl, model); ok {
+		if staged.remove {
+			return nil, false, nil
+		}
+		return staged.model, true, nil
+	}
+
+	return t.base.GetWithPrimaryKey(model)
+}
+
+func (t *tx) GetAll(model Model) ([]Model, error) {
+
+	if t.done {
+		return nil, ErrTxDone
+	}
+
+	tbl, err := lookupTable(model)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := t.base.GetAll(model)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.merge(tbl, rows), nil
+}
+
+func (t *tx) Query(model Model, q Query) ([]Model, error) {
+
+	if t.done {
+		return nil, ErrTxDone
+	}
+
+	tbl, err := lookupTable(model)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(t.staged[tbl.name]) == 0 {
+		return t.base.Query(model, q)
+	}
+
+	//staged rows can enter or leave the result, so sort and page after merging
+	rows, err := t.base.Query(model, Query{Filters: q.Filters, IncludeDeleted: q.IncludeDeleted})
+	if err != nil {
+		return nil, err
+	}
+
+	return q.apply(t.merge(tbl, rows))
+}
+
+// merge overlays the staged writes of a table on rows read from the base
+func (t *tx) merge(tbl *table, rows []Model) []Model {
+
+	staged := t.staged[tbl.name]
+	if len(staged) == 0 {
+		return rows
+	}
+
+	var resp = make([]Model, 0, len(rows)+len(staged))
+
+	for _, row := range rows {
+		if _, ok := staged[row.PrimaryKey()]; !ok {
+			resp = append(resp, row)
+		}
+	}
+
+	for _, i := range staged {
+		if !t.writes[i].remove {
+			resp = append(resp, t.writes[i].model)
+		}
+	}
+
+	return resp
+}
+
+func (t *tx) Clock() Clock {
+	return t.base.clock
+}
+
+func (t *tx) Begin() (Tx, error) {
+	return nil, fmt.Errorf("nested transactions are not supported")
+}
+
+func (t *tx) Commit() error {
+
+	if t.done {
+		return ErrTxDone
+	}
+	t.done = true
+
+	if len(t.writes) == 0 {
+		return nil
+	}
+
+	_, err := t.target.commit(t.writes)
+	return err
+}
+
+func (t *tx) Rollback() error {
+
+	if t.done {
+		return ErrTxDone
+	}
+	t.done = true
+
+	return nil
+}
@@ -1,10 +1,20 @@
 package model
 
+import "time"
+
+func init() {
+	RegisterTable(User{}, SoftDelete())
+}
+
 type User struct {
 	Name        string
 	Email       string
-	CreditLimit int //stored as cents, instead of dollars
-	Dues        int
+	Currency    Currency //of the credit limit and dues, empty for DefaultCurrency
+	CreditLimit Money
+	Version     int64     //bumped by the model manager on every write
+	CreatedAt   time.Time //stamped by the model manager
+	UpdatedAt   time.Time
+	DeletedAt   time.Time //set when the user is soft deleted
 }
 
 func (m User) TableName() string {
@@ -15,9 +25,13 @@ func (m User) PrimaryKey() string {
 	return m.Name
 }
 
-func (m User) AllowAmount(amountToTransfer int) bool {
+// AllowAmount reports whether a user owing dues, the balance of their
+// receivable, can be charged amountToTransfer more. negative dues are a
+// credit, which is used up before the credit limit.
+func (m User) AllowAmount(dues Money, amountToTransfer Money) bool {
 
-	if m.Dues+amountToTransfer > m.CreditLimit {
+	dues, err := dues.Add(amountToTransfer)
+	if err != nil || dues > m.CreditLimit {
 		return false
 	}
 	return true
@@ -0,0 +1,825 @@
+package billing
+
+import (
+	"fmt"
+	"pay-later/integration/log"
+	"pay-later/model"
+	"pay-later/service/ledger"
+	"pay-later/service/transaction"
+	"pay-later/service/user"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MaxCycleDay keeps every cycle day in every month
+const MaxCycleDay = 28
+
+var (
+	defaultCycleDayMu sync.RWMutex
+	defaultCycleDay   = 1
+
+	defaultTermsMu sync.RWMutex
+	defaultTerms   = Terms{
+		DueDays:    20,
+		GraceDays:  3,
+		Interest:   model.InterestPolicy{DayCount: model.DayCountActual365, Compounding: model.CompoundMonthly},
+		MinimumDue: model.MinimumDuePolicy{Rate: 500},
+	}
+)
+
+// Terms are when a statement has to be paid and what paying late costs
+type Terms struct {
+	DueDays    int //from the close of a cycle to its due date
+	GraceDays  int //after the due date in which a payment still counts as on time
+	LateFee    model.LateFeePolicy
+	Interest   model.InterestPolicy
+	MinimumDue model.MinimumDuePolicy
+}
+
+// Validate makes sure a statement is late before the next one closes, so
+// that only the last statement of a user is ever charged a late fee
+func (t Terms) Validate() error {
+
+	if t.DueDays < 0 || t.GraceDays < 0 || t.DueDays+t.GraceDays >= MaxCycleDay {
+		return fmt.Errorf("invalid terms, due and grace days have to add up to less than %d", MaxCycleDay)
+	}
+
+	return t.Interest.Validate()
+}
+
+// DefaultTerms are due 20 days after the cycle closes with 3 days of grace,
+// a minimum due of 5% of the principal, no late fee and no interest, unless
+// changed with SetDefaultTerms
+func DefaultTerms() Terms {
+
+	defaultTermsMu.RLock()
+	defer defaultTermsMu.RUnlock()
+
+	return defaultTerms
+}
+
+func SetDefaultTerms(t Terms) error {
+
+	if err := t.Validate(); err != nil {
+		return err
+	}
+
+	defaultTermsMu.Lock()
+	defaultTerms = t
+	defaultTermsMu.Unlock()
+
+	return nil
+}
+
+// DefaultCycleDay is the day of the month billing cycles close on, the 1st
+// unless changed with SetDefaultCycleDay
+func DefaultCycleDay() int {
+
+	defaultCycleDayMu.RLock()
+	defer defaultCycleDayMu.RUnlock()
+
+	return defaultCycleDay
+}
+
+// SetDefaultCycleDay changes the day cycles close on. statements already
+// closed are kept, the next one starts where the last one ended.
+func SetDefaultCycleDay(day int) error {
+
+	if day < 1 || day > MaxCycleDay {
+		return fmt.Errorf("invalid cycle day %d, use 1 to %d", day, MaxCycleDay)
+	}
+
+	defaultCycleDayMu.Lock()
+	defaultCycleDay = day
+	defaultCycleDayMu.Unlock()
+
+	return nil
+}
+
+type BillingService interface {
+	GenerateStatements(time.Time) ([]model.Statement, error)
+	GetStatement(string, string) (*model.Statement, error)
+	GetStatements(string) ([]model.Statement, error)
+	ChargeLateFees(time.Time) ([]model.LateFeeTransfer, error)
+	ProjectInterest(string) (*model.InterestTransfer, error)
+}
+
+type billingService struct {
+	l          log.Logger
+	usrSrv     user.UserService
+	txnService transaction.TransactionService
+	ledgerSrv  ledger.LedgerService
+	dbSrv      model.ModelManager
+	cycleDay   int
+	terms      Terms
+}
+
+func NewBillingService(l log.Logger, usrSrv user.UserService, txnSrv transaction.TransactionService, ledgerSrv ledger.LedgerService, dbSrv model.ModelManager) BillingService {
+	return &billingService{
+		l, usrSrv, txnSrv, ledgerSrv, dbSrv, DefaultCycleDay(), DefaultTerms(),
+	}
+}
+
+// cycleStart is the start of the cycle holding t, midnight UTC of the last
+// cycle day on or before t
+func (b billingService) cycleStart(t time.Time) time.Time {
+
+	t = t.UTC()
+
+	start := time.Date(t.Year(), t.Month(), b.cycleDay, 0, 0, 0, 0, time.UTC)
+	if start.After(t) {
+		start = start.AddDate(0, -1, 0)
+	}
+
+	return start
+}
+
+// firstCycleStart is the start of the cycle the user was created in. users
+// stored before rows were timestamped have no CreatedAt, theirs is the cycle
+// of their first journal line, or the one holding asOf if they have none.
+func (b billingService) firstCycleStart(user model.User, asOf time.Time) (time.Time, error) {
+
+	if !user.CreatedAt.IsZero() {
+		return b.cycleStart(user.CreatedAt), nil
+	}
+
+	lines, err := b.ledgerSrv.GetAccountLines(model.UserReceivable(user.Name, user.Currency), time.Time{}, asOf)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	for _, line := range lines {
+		if !line.PostedAt.IsZero() {
+			return b.cycleStart(line.PostedAt), nil
+		}
+	}
+
+	return b.cycleStart(asOf), nil
+}
+
+// nextCycleEnd is when a cycle starting at start closes, the cycle day of
+// the next month. a change of cycle day makes the next cycle shorter or
+// longer than a month, yet every cycle still starts in a month of its own.
+func (b billingService) nextCycleEnd(start time.Time) time.Time {
+	return time.Date(start.Year(), start.Month()+1, b.cycleDay, 0, 0, 0, 0, time.UTC)
+}
+
+// GenerateStatements closes every cycle of every user that ended by asOf and
+// has no statement yet, oldest first, charging the interest of the cycle
+// first. a user's first cycle starts with the one they were created in, every
+// later one where the previous one ended. running it again for the same asOf
+// closes nothing new.
+func (b billingService) GenerateStatements(asOf time.Time) ([]model.Statement, error) {
+
+	users, err := b.dbSrv.Query(model.User{}, model.NewQuery())
+	if err != nil {
+		return nil, err
+	}
+
+	var resp = make([]model.Statement, 0)
+
+	for _, m := range users {
+		user, ok := m.(model.User)
+		if !ok {
+			return nil, fmt.Errorf("can not able to type assert user")
+		}
+
+		statements, err := b.generateForUser(user, asOf)
+		if err != nil {
+			return resp, err
+		}
+		resp = append(resp, statements...)
+	}
+
+	return resp, nil
+}
+
+func (b billingService) generateForUser(user model.User, asOf time.Time) ([]model.Statement, error) {
+
+	var resp []model.Statement
+
+	statements, err := b.GetStatements(user.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	start, err := b.firstCycleStart(user, asOf)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(statements) > 0 {
+		start = statements[len(statements)-1].CycleEnd
+	}
+
+	for end := b.nextCycleEnd(start); !end.After(asOf); start, end = end, b.nextCycleEnd(end) {
+
+		if _, err := b.chargeInterest(user, statements, start, end); err != nil {
+			return resp, err
+		}
+
+		statement, err := b.closeStatement(user, start, end)
+		if err != nil {
+			return resp, err
+		}
+
+		sModel, err := b.dbSrv.Upsert(*statement)
+		if err != nil {
+			b.l.ErrorD("can not able to store statement", log.Fields{"statement": statement})
+			return resp, err
+		}
+
+		resp = append(resp, sModel.(model.Statement))
+		statements = append(statements, sModel.(model.Statement))
+	}
+
+	return resp, nil
+}
+
+// closeStatement sums the receivable of the user over a cycle
+func (b billingService) closeStatement(user model.User, start time.Time, end time.Time) (*model.Statement, error) {
+
+	account := model.UserReceivable(user.Name, user.Currency)
+
+	statement := &model.Statement{
+		UserName:   user.Name,
+		Month:      start.Format("2006-01"),
+		Currency:   account.Currency,
+		CycleStart: start,
+		CycleEnd:   end,
+		DueDate:    end.AddDate(0, 0, b.terms.DueDays),
+	}
+
+	opening, err := b.ledgerSrv.GetAccountLines(account, time.Time{}, start)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range opening {
+		if statement.OpeningBalance, err = addNet(statement.OpeningBalance, line); err != nil {
+			return nil, err
+		}
+	}
+
+	lines, err := b.ledgerSrv.GetAccountLines(account, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range lines {
+		entry, err := b.ledgerSrv.GetEntry(line.EntryID)
+		if err != nil {
+			return nil, err
+		}
+
+		switch entry.Type {
+		case model.USER_MERCHANT_TRANSFER:
+			statement.Purchases, err = addNet(statement.Purchases, line)
+		case model.USER_PAYBACK_TRANSFER:
+			var paid model.Money
+			if paid, err = line.Credit.Sub(line.Debit); err == nil {
+				statement.Paybacks, err = statement.Paybacks.Add(paid)
+			}
+		case model.MERCHANT_REFUND:
+			var refunded model.Money
+			if refunded, err = line.Credit.Sub(line.Debit); err == nil {
+				statement.Refunds, err = statement.Refunds.Add(refunded)
+			}
+		case model.LATE_FEE:
+			statement.Fees, err = addNet(statement.Fees, line)
+		case model.INTEREST:
+			statement.Interest, err = addNet(statement.Interest, line)
+		default:
+			statement.Adjustments, err = addNet(statement.Adjustments, line)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	closing := statement.OpeningBalance
+	for _, amount := range []model.Money{statement.Purchases, statement.Fees, statement.Interest, statement.Adjustments} {
+		if closing, err = closing.Add(amount); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, amount := range []model.Money{statement.Paybacks, statement.Refunds} {
+		if closing, err = closing.Sub(amount); err != nil {
+			return nil, err
+		}
+	}
+	statement.ClosingBalance = closing
+
+	if statement.MinimumDue, err = b.terms.MinimumDue.MinimumDue(*statement); err != nil {
+		return nil, err
+	}
+
+	return statement, nil
+}
+
+// settles tells whether entries of the type pay off what a statement closed
+// with, paybacks and refunds of purchases
+func settles(entryType model.TransactionType) bool {
+	return entryType == model.USER_PAYBACK_TRANSFER || entryType == model.MERCHANT_REFUND
+}
+
+// addNet adds the debit less the credit of line to amount
+func addNet(amount model.Money, line model.JournalLine) (model.Money, error) {
+
+	net, err := line.Debit.Sub(line.Credit)
+	if err != nil {
+		return 0, err
+	}
+
+	return amount.Add(net)
+}
+
+func (b billingService) lastStatement(userName string) (*model.Statement, error) {
+
+	models, err := b.dbSrv.Query(model.Statement{}, model.NewQuery().Where("UserName", model.Eq, userName).OrderBy("CycleEnd", true).Page(1, 0))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(models) == 0 {
+		return nil, nil
+	}
+
+	statement, ok := models[0].(model.Statement)
+	if !ok {
+		return nil, fmt.Errorf("can not able to type assert statement")
+	}
+
+	return &statement, nil
+}
+
+// GetStatement is the statement of the user for the cycle starting in month,
+// 2006-01
+func (b billingService) GetStatement(userName string, month string) (*model.Statement, error) {
+
+	if _, err := time.Parse("2006-01", month); err != nil {
+		return nil, fmt.Errorf("invalid month %q, use 2006-01", month)
+	}
+
+	sModel, found, err := b.dbSrv.GetWithPrimaryKey(model.Statement{UserName: userName, Month: month})
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, fmt.Errorf("no statement for %s in %s", userName, month)
+	}
+
+	statement, ok := sModel.(model.Statement)
+	if !ok {
+		return nil, fmt.Errorf("can not able to type assert statement")
+	}
+
+	return &statement, nil
+}
+
+// GetStatements are the statements of the user, oldest first
+func (b billingService) GetStatements(userName string) ([]model.Statement, error) {
+
+	models, err := b.dbSrv.Query(model.Statement{}, model.NewQuery().Where("UserName", model.Eq, userName).OrderBy("CycleEnd", false))
+	if err != nil {
+		return nil, err
+	}
+
+	var resp = make([]model.Statement, 0, len(models))
+	for _, m := range models {
+		statement, ok := m.(model.Statement)
+		if !ok {
+			return nil, fmt.Errorf("can not able to type assert statement")
+		}
+		resp = append(resp, statement)
+	}
+
+	return resp, nil
+}
+
+// ChargeLateFees charges the late fee to every user who paid back less than
+// the minimum due of their last statement by the end of its grace period,
+// once per statement. the fee is on what is unpaid, the closing balance less
+// the paybacks and refunds made since the close.
+func (b billingService) ChargeLateFees(asOf time.Time) ([]model.LateFeeTransfer, error) {
+
+	var resp = make([]model.LateFeeTransfer, 0)
+
+	if b.terms.LateFee.IsZero() {
+		return resp, nil
+	}
+
+	users, err := b.dbSrv.Query(model.User{}, model.NewQuery().OrderBy("Name", false))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range users {
+		user, ok := m.(model.User)
+		if !ok {
+			return nil, fmt.Errorf("can not able to type assert user")
+		}
+
+		statement, err := b.lastStatement(user.Name)
+		if err != nil {
+			return resp, err
+		}
+
+		if statement == nil || statement.ClosingBalance <= 0 || asOf.Before(b.lateAfter(*statement)) {
+			continue
+		}
+
+		fee, err := b.chargeLateFee(user, *statement)
+		if err != nil {
+			return resp, err
+		}
+
+		if fee != nil {
+			resp = append(resp, *fee)
+		}
+	}
+
+	return resp, nil
+}
+
+// dueDate is the last day statement can be paid on, statements closed
+// before due dates existed are due by the current terms
+func (b billingService) dueDate(statement model.Statement) time.Time {
+
+	if statement.DueDate.IsZero() {
+		return statement.CycleEnd.AddDate(0, 0, b.terms.DueDays)
+	}
+
+	return statement.DueDate
+}
+
+// minimumDue is the minimum amount due of statement, statements closed
+// before minimum amounts existed are due by the current terms
+func (b billingService) minimumDue(statement model.Statement) (model.Money, error) {
+
+	if statement.MinimumDue == 0 {
+		return b.terms.MinimumDue.MinimumDue(statement)
+	}
+
+	return statement.MinimumDue, nil
+}
+
+// lateAfter is when a payment for statement stops being on time, the end of
+// the last day of grace
+func (b billingService) lateAfter(statement model.Statement) time.Time {
+	return b.dueDate(statement).AddDate(0, 0, b.terms.GraceDays+1)
+}
+
+func (b billingService) chargeLateFee(user model.User, statement model.Statement) (*model.LateFeeTransfer, error) {
+
+	unlock := b.usrSrv.LockUser(user.Name)
+	defer unlock()
+
+	charged, err := b.dbSrv.Query(model.LateFeeTransfer{}, model.NewQuery().Where("UserName", model.Eq, user.Name).Where("Month", model.Eq, statement.Month))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(charged) > 0 {
+		return nil, nil
+	}
+
+	account := model.UserReceivable(user.Name, statement.Currency)
+
+	lines, err := b.ledgerSrv.GetAccountLines(account, statement.CycleEnd, b.lateAfter(statement))
+	if err != nil {
+		return nil, err
+	}
+
+	unpaid := statement.ClosingBalance
+	for _, line := range lines {
+		entry, err := b.ledgerSrv.GetEntry(line.EntryID)
+		if err != nil {
+			return nil, err
+		}
+
+		if !settles(entry.Type) {
+			continue
+		}
+
+		if unpaid, err = addNet(unpaid, line); err != nil {
+			return nil, err
+		}
+	}
+
+	minimumDue, err := b.minimumDue(statement)
+	if err != nil {
+		return nil, err
+	}
+
+	paid, err := statement.ClosingBalance.Sub(unpaid)
+	if err != nil || paid >= minimumDue {
+		return nil, err
+	}
+
+	fee, err := b.terms.LateFee.Fee(unpaid, statement.Currency, model.DefaultRoundingPolicy())
+	if err != nil || fee == 0 {
+		return nil, err
+	}
+
+	tx, err := b.dbSrv.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	txnSrv := b.txnService.WithModelManager(tx)
+	ledgerSrv := b.ledgerSrv.WithModelManager(tx)
+
+	transferID, err := uuid.NewUUID()
+	if err != nil {
+		return nil, fmt.Errorf("can not able to generate transfer id")
+	}
+
+	fModel, err := tx.Upsert(model.LateFeeTransfer{
+		ID:       transferID,
+		UserName: user.Name,
+		Month:    statement.Month,
+		Amount:   fee,
+		Currency: statement.Currency,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	transfer, ok := fModel.(model.LateFeeTransfer)
+	if !ok {
+		return nil, fmt.Errorf("can not able to type assert model")
+	}
+
+	txnID, err := uuid.NewUUID()
+	if err != nil {
+		return nil, fmt.Errorf("can not able to generate transaction id")
+	}
+
+	_, err = txnSrv.CreateTransaction(&model.Transaction{
+		ID:              txnID,
+		TransferID:      transfer.ID,
+		Type:            model.LATE_FEE,
+		SourceName:      transfer.UserName,
+		DestinationName: model.LENDER_ACCOUNT_NAME,
+		Amount:          fee,
+		Currency:        transfer.Currency,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = ledgerSrv.PostEntry(
+		model.JournalEntry{TransferID: transfer.ID, Type: model.LATE_FEE, Currency: transfer.Currency},
+		model.Debit(account, fee),
+		model.Credit(model.LenderRevenue(transfer.Currency), fee),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		b.l.Error("error committing late fee", log.Fields{"transfer": transfer})
+		return nil, err
+	}
+
+	return &transfer, nil
+}
+
+// accrueInterest is the interest on the overdue balance of every day from
+// start up to end. a day is overdue once it is past the due date of a
+// statement, and the overdue balance is what the last such statement closed
+// with less every payback and refund since its close up to the end of the day.
+func (b billingService) accrueInterest(user model.User, statements []model.Statement, start time.Time, end time.Time) (model.Money, error) {
+
+	if b.terms.Interest.APR == 0 || len(statements) == 0 {
+		return 0, nil
+	}
+
+	//the statement overdue on the first day is the oldest one that matters
+	first := 0
+	for i, s := range statements {
+		if !start.Before(b.dueDate(s).AddDate(0, 0, 1)) {
+			first = i
+		}
+	}
+
+	account := model.UserReceivable(user.Name, user.Currency)
+
+	lines, err := b.ledgerSrv.GetAccountLines(account, statements[first].CycleEnd, end)
+	if err != nil {
+		return 0, err
+	}
+
+	var paybacks []model.JournalLine
+	for _, line := range lines {
+		entry, err := b.ledgerSrv.GetEntry(line.EntryID)
+		if err != nil {
+			return 0, err
+		}
+
+		if settles(entry.Type) {
+			paybacks = append(paybacks, line)
+		}
+	}
+
+	overdue := func(day time.Time) (model.Money, error) {
+
+		var statement *model.Statement
+		for i := first; i < len(statements); i++ {
+			if !day.Before(b.dueDate(statements[i]).AddDate(0, 0, 1)) {
+				statement = &statements[i]
+			}
+		}
+
+		if statement == nil {
+			return 0, nil
+		}
+
+		var err error
+		balance := statement.ClosingBalance
+		for _, line := range paybacks {
+			if line.PostedAt.Before(statement.CycleEnd) || !line.PostedAt.Before(day.AddDate(0, 0, 1)) {
+				continue
+			}
+
+			if balance, err = addNet(balance, line); err != nil {
+				return 0, err
+			}
+		}
+
+		return balance, nil
+	}
+
+	return b.terms.Interest.Accrue(start, end, model.DefaultRoundingPolicy(), overdue)
+}
+
+// chargeInterest posts the interest of the cycle from start to end, once. it
+// is posted at the last instant of the cycle so that it falls in the
+// statement of the cycle rather than the next one.
+func (b billingService) chargeInterest(user model.User, statements []model.Statement, start time.Time, end time.Time) (*model.InterestTransfer, error) {
+
+	unlock := b.usrSrv.LockUser(user.Name)
+	defer unlock()
+
+	month := start.Format("2006-01")
+
+	charged, err := b.dbSrv.Query(model.InterestTransfer{}, model.NewQuery().Where("UserName", model.Eq, user.Name).Where("Month", model.Eq, month))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(charged) > 0 {
+		return nil, nil
+	}
+
+	interest, err := b.accrueInterest(user, statements, start, end)
+	if err != nil || interest == 0 {
+		return nil, err
+	}
+
+	tx, err := b.dbSrv.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	txnSrv := b.txnService.WithModelManager(tx)
+	ledgerSrv := b.ledgerSrv.WithModelManager(tx)
+
+	transferID, err := uuid.NewUUID()
+	if err != nil {
+		return nil, fmt.Errorf("can not able to generate transfer id")
+	}
+
+	account := model.UserReceivable(user.Name, user.Currency)
+
+	iModel, err := tx.Upsert(model.InterestTransfer{
+		ID:          transferID,
+		UserName:    user.Name,
+		Month:       month,
+		Amount:      interest,
+		Currency:    account.Currency,
+		APR:         b.terms.Interest.APR,
+		DayCount:    b.terms.Interest.DayCount,
+		Compounding: b.terms.Interest.Compounding,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	transfer, ok := iModel.(model.InterestTransfer)
+	if !ok {
+		return nil, fmt.Errorf("can not able to type assert model")
+	}
+
+	txnID, err := uuid.NewUUID()
+	if err != nil {
+		return nil, fmt.Errorf("can not able to generate transaction id")
+	}
+
+	_, err = txnSrv.CreateTransaction(&model.Transaction{
+		ID:              txnID,
+		TransferID:      transfer.ID,
+		Type:            model.INTEREST,
+		SourceName:      transfer.UserName,
+		DestinationName: model.LENDER_ACCOUNT_NAME,
+		Amount:          interest,
+		Currency:        transfer.Currency,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = ledgerSrv.PostEntry(
+		model.JournalEntry{TransferID: transfer.ID, Type: model.INTEREST, Currency: transfer.Currency, PostedAt: end.Add(-time.Nanosecond)},
+		model.Debit(account, interest),
+		model.Credit(model.LenderRevenue(transfer.Currency), interest),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		b.l.Error("error committing interest", log.Fields{"transfer": transfer})
+		return nil, err
+	}
+
+	return &transfer, nil
+}
+
+// ProjectInterest is a dry run of the interest the cycle after the user's
+// last statement would be charged when it closes, if nothing more were paid
+// back. nothing is posted.
+func (b billingService) ProjectInterest(userName string) (*model.InterestTransfer, error) {
+
+	user, err := b.usrSrv.GetUserWithName(userName)
+	if err != nil {
+		return nil, err
+	}
+
+	statements, err := b.GetStatements(user.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	start, err := b.firstCycleStart(*user, b.dbSrv.Clock().Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if len(statements) > 0 {
+		start = statements[len(statements)-1].CycleEnd
+	}
+
+	interest, err := b.accrueInterest(*user, statements, start, b.nextCycleEnd(start))
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.InterestTransfer{
+		UserName:    user.Name,
+		Month:       start.Format("2006-01"),
+		Amount:      interest,
+		Currency:    user.Currency.OrDefault(),
+		APR:         b.terms.Interest.APR,
+		DayCount:    b.terms.Interest.DayCount,
+		Compounding: b.terms.Interest.Compounding,
+	}, nil
+}
+
+// StatementJob closes the statements of every cycle ending by the day it
+// runs for
+type StatementJob struct {
+	Billing BillingService
+}
+
+func (j StatementJob) Name() string {
+	return "statements"
+}
+
+func (j StatementJob) Run(day time.Time) error {
+	_, err := j.Billing.GenerateStatements(day)
+	return err
+}
+
+// LateFeeJob charges the late fees of statements whose grace period ended by
+// the day it runs for
+type LateFeeJob struct {
+	Billing BillingService
+}
+
+func (j LateFeeJob) Name() string {
+	return "late fees"
+}
+
+func (j LateFeeJob) Run(day time.Time) error {
+	_, err := j.Billing.ChargeLateFees(day)
+	return err
+}
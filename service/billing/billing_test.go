@@ -0,0 +1,421 @@
+package billing
+
+import (
+	"io/ioutil"
+	"pay-later/integration/email"
+	"pay-later/integration/log"
+	"pay-later/model"
+	"pay-later/service/fx"
+	"pay-later/service/ledger"
+	"pay-later/service/merchant"
+	"pay-later/service/transaction"
+	"pay-later/service/transfer"
+	"pay-later/service/user"
+	"testing"
+	"time"
+)
+
+// testBook is a book of users buying from m1, on a clock that only moves
+// when told to, starting on 2026-01-05
+type testBook struct {
+	t         *testing.T
+	l         log.Logger
+	clock     *model.ManualClock
+	db        model.ModelManager
+	users     user.UserService
+	txns      transaction.TransactionService
+	ledger    ledger.LedgerService
+	transfers transfer.TransferService
+}
+
+func newTestBook(t *testing.T, users ...string) testBook {
+	t.Helper()
+
+	l := log.NewLogger(log.SetOutput(ioutil.Discard))
+	clock := model.NewManualClock(date(t, "2026-01-05T10:00:00Z"))
+
+	db, err := model.NewModelManager(l, model.SetClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	emailSrv := email.NewEmailService(l)
+	txnSrv := transaction.NewTransactionService(db, l)
+	usrSrv := user.NewUserService(db, emailSrv, l)
+	mrtSrv := merchant.NewMerchantService(db, emailSrv, l)
+	ledgerSrv := ledger.NewLedgerService(db, l)
+
+	b := testBook{
+		t:         t,
+		l:         l,
+		clock:     clock,
+		db:        db,
+		users:     usrSrv,
+		txns:      txnSrv,
+		ledger:    ledgerSrv,
+		transfers: transfer.NewTransferService(l, txnSrv, usrSrv, mrtSrv, fx.NewFXService(l, db), ledgerSrv, db),
+	}
+
+	for _, name := range users {
+		if _, err := usrSrv.CreateNewUser(name, name+"@users.com", b.money("100000.00"), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := mrtSrv.CreateNewMerchant("m1", "m1@merchants.com", 150, "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+// billing is a billing service over the book, cycles closing on the 1st
+func (b testBook) billing(terms Terms) billingService {
+	return billingService{b.l, b.users, b.txns, b.ledger, b.db, 1, terms}
+}
+
+func date(t *testing.T, str string) time.Time {
+	t.Helper()
+
+	d, err := time.Parse(time.RFC3339, str)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return d
+}
+
+func (b testBook) money(str string) model.Money {
+	b.t.Helper()
+
+	m, err := model.ParseMoney(str)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+
+	return m
+}
+
+// at moves the clock to str, the time the next transfers are posted at
+func (b testBook) at(str string) time.Time {
+	b.t.Helper()
+
+	d := date(b.t, str)
+	b.clock.Set(d)
+
+	return d
+}
+
+func (b testBook) purchase(userName string, amount string) {
+	b.t.Helper()
+
+	if _, err := b.transfers.CreateInterTransfer(userName, "m1", b.money(amount)); err != nil {
+		b.t.Fatal(err)
+	}
+}
+
+func (b testBook) payback(userName string, amount string) {
+	b.t.Helper()
+
+	if _, err := b.transfers.CreatePaybackTransfer(userName, b.money(amount)); err != nil {
+		b.t.Fatal(err)
+	}
+}
+
+func terms(t *testing.T, lateFee string, lateFeeCap string, minimumDue string, minimumDueFloor string, interest model.InterestPolicy) Terms {
+	t.Helper()
+
+	fee, err := model.ParseLateFeePolicy(lateFee, lateFeeCap)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	minimum, err := model.ParseMinimumDuePolicy(minimumDue, minimumDueFloor)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if interest.DayCount == "" {
+		interest = model.InterestPolicy{DayCount: model.DayCountActual365, Compounding: model.CompoundMonthly}
+	}
+
+	return Terms{DueDays: 20, GraceDays: 3, LateFee: fee, Interest: interest, MinimumDue: minimum}
+}
+
+func (b testBook) generate(srv billingService, asOf string) []model.Statement {
+	b.t.Helper()
+
+	statements, err := srv.GenerateStatements(b.at(asOf))
+	if err != nil {
+		b.t.Fatal(err)
+	}
+
+	return statements
+}
+
+func (b testBook) statement(srv billingService, userName string, month string) model.Statement {
+	b.t.Helper()
+
+	statement, err := srv.GetStatement(userName, month)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+
+	return *statement
+}
+
+func TestGenerateStatements(t *testing.T) {
+
+	b := newTestBook(t, "u1")
+	srv := b.billing(terms(t, "0", "", "5%", "", model.InterestPolicy{}))
+
+	b.purchase("u1", "1000.00")
+	b.at("2026-01-20T10:00:00Z")
+	b.purchase("u1", "500.00")
+
+	b.at("2026-02-03T10:00:00Z")
+	b.payback("u1", "300.00")
+	b.at("2026-02-10T10:00:00Z")
+	b.purchase("u1", "200.00")
+
+	if statements := b.generate(srv, "2026-01-31T23:59:59Z"); len(statements) != 0 {
+		t.Fatalf("closed %d statements before the cycle ended", len(statements))
+	}
+
+	statements := b.generate(srv, "2026-03-01T00:00:00Z")
+	if len(statements) != 2 {
+		t.Fatalf("closed %d statements, want the ones of January and February", len(statements))
+	}
+
+	jan, feb := statements[0], statements[1]
+
+	if jan.Month != "2026-01" || !jan.CycleStart.Equal(date(t, "2026-01-01T00:00:00Z")) || !jan.CycleEnd.Equal(date(t, "2026-02-01T00:00:00Z")) {
+		t.Errorf("first cycle %s from %s to %s, want 2026-01 from the 1st to the 1st", jan.Month, jan.CycleStart, jan.CycleEnd)
+	}
+
+	if !jan.DueDate.Equal(date(t, "2026-02-21T00:00:00Z")) {
+		t.Errorf("due on %s, want 2026-02-21", jan.DueDate)
+	}
+
+	if jan.OpeningBalance != 0 || jan.Purchases != b.money("1500.00") || jan.Paybacks != 0 || jan.ClosingBalance != b.money("1500.00") {
+		t.Errorf("january %+v, want 1500.00 of purchases closing at 1500.00", jan)
+	}
+
+	if feb.Month != "2026-02" || !feb.CycleStart.Equal(jan.CycleEnd) {
+		t.Errorf("second cycle %s from %s, want 2026-02 from where the first ended", feb.Month, feb.CycleStart)
+	}
+
+	if feb.OpeningBalance != jan.ClosingBalance || feb.Purchases != b.money("200.00") || feb.Paybacks != b.money("300.00") || feb.ClosingBalance != b.money("1400.00") {
+		t.Errorf("february %+v, want 1500.00 opening, 200.00 of purchases and 300.00 paid back closing at 1400.00", feb)
+	}
+
+	//running it again, even later in the cycle, closes nothing new
+	if statements := b.generate(srv, "2026-03-01T00:00:00Z"); len(statements) != 0 {
+		t.Errorf("closed %d statements again", len(statements))
+	}
+
+	if statements := b.generate(srv, "2026-03-15T00:00:00Z"); len(statements) != 0 {
+		t.Errorf("closed %d statements inside the open cycle", len(statements))
+	}
+
+	stored, err := srv.GetStatements("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(stored) != 2 || stored[1] != feb {
+		t.Errorf("got statements %+v, want january and february as closed", stored)
+	}
+}
+
+func TestChargeLateFees(t *testing.T) {
+
+	b := newTestBook(t, "paid", "short", "none", "late")
+	srv := b.billing(terms(t, "2%", "25", "5%", "100", model.InterestPolicy{}))
+
+	b.purchase("paid", "1000.00")
+	b.purchase("short", "1000.00")
+	b.purchase("none", "5000.00")
+	b.purchase("late", "1000.00")
+
+	b.generate(srv, "2026-02-01T00:00:00Z")
+
+	if due := b.statement(srv, "paid", "2026-01").MinimumDue; due != b.money("100.00") {
+		t.Fatalf("minimum due %s, want the floor of 100.00", due)
+	}
+
+	//on the last day of grace, due on the 21st with 3 days of grace
+	b.at("2026-02-24T18:00:00Z")
+	b.payback("paid", "100.00")
+	b.payback("short", "99.99")
+
+	fees, err := srv.ChargeLateFees(b.clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fees) != 0 {
+		t.Fatalf("charged %d late fees inside the grace period", len(fees))
+	}
+
+	b.at("2026-02-25T00:00:00Z")
+	b.payback("late", "100.00")
+
+	fees, err = srv.ChargeLateFees(b.clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]model.Money{
+		"short": b.money("18.00"), //2% of the 900.01 left
+		"none":  b.money("25.00"), //2% of 5000.00 is over the cap
+		"late":  b.money("20.00"), //paid after the grace period
+	}
+
+	if len(fees) != len(want) {
+		t.Fatalf("charged %+v, want %v", fees, want)
+	}
+
+	for _, fee := range fees {
+		if fee.Amount != want[fee.UserName] || fee.Month != "2026-01" {
+			t.Errorf("charged %s %s for %s, want %s for 2026-01", fee.UserName, fee.Amount, fee.Month, want[fee.UserName])
+		}
+	}
+
+	//a single fee per statement
+	for _, day := range []string{"2026-02-25T12:00:00Z", "2026-02-28T00:00:00Z"} {
+		if fees, err = srv.ChargeLateFees(b.at(day)); err != nil || len(fees) != 0 {
+			t.Errorf("charged %+v, %v again on %s", fees, err, day)
+		}
+	}
+
+	charged, err := b.db.GetAll(model.LateFeeTransfer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(charged) != len(want) {
+		t.Errorf("%d late fees stored, want %d", len(charged), len(want))
+	}
+
+	dues, err := b.users.GetUserDues("none")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dues != b.money("5025.00") {
+		t.Errorf("dues %s, want the 5000.00 unpaid and the 25.00 fee", dues)
+	}
+
+	//the fee is on the next statement, and due in full on top of the minimum
+	b.generate(srv, "2026-03-01T00:00:00Z")
+
+	feb := b.statement(srv, "none", "2026-02")
+	if feb.Fees != b.money("25.00") || feb.ClosingBalance != b.money("5025.00") || feb.MinimumDue != b.money("275.00") {
+		t.Errorf("february %+v, want 25.00 of fees closing at 5025.00 with 275.00 due", feb)
+	}
+}
+
+func TestAccrueInterestFromTheDueDate(t *testing.T) {
+
+	b := newTestBook(t, "u1")
+
+	b.purchase("u1", "1000.00")
+
+	monthly := b.billing(terms(t, "0", "", "5%", "", model.InterestPolicy{}))
+	b.generate(monthly, "2026-02-01T00:00:00Z")
+
+	//past the due date of the 21st on the 22nd, 23rd and 24th at 1000.00,
+	//and from the 25th to the 28th at 500.00
+	b.at("2026-02-25T10:00:00Z")
+	b.payback("u1", "500.00")
+	b.at("2026-03-01T00:00:00Z")
+
+	statements, err := monthly.GetStatements("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := b.users.GetUserWithName("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start, end := date(t, "2026-02-01T00:00:00Z"), date(t, "2026-03-01T00:00:00Z")
+
+	tests := []struct {
+		dayCount model.DayCount
+		want     model.Money
+	}{
+		{model.DayCountActual365, b.money("4.93")}, //5000.00 day balance at 36% over 365 days is 4.9315
+		{model.DayCountActual360, b.money("5.00")},
+	}
+
+	for _, tt := range tests {
+		srv := b.billing(terms(t, "0", "", "5%", "", model.InterestPolicy{APR: 3600, DayCount: tt.dayCount, Compounding: model.CompoundMonthly}))
+
+		before, err := srv.accrueInterest(*u, statements, start, date(t, "2026-02-22T00:00:00Z"))
+		if err != nil || before != 0 {
+			t.Errorf("%s: %s, %v accrued up to the due date, want none", tt.dayCount, before, err)
+		}
+
+		interest, err := srv.accrueInterest(*u, statements, start, end)
+		if err != nil || interest != tt.want {
+			t.Errorf("%s: accrued %s, %v, want %s", tt.dayCount, interest, err, tt.want)
+		}
+	}
+
+	//the interest is posted when the cycle closes, on its statement
+	srv := b.billing(terms(t, "0", "", "5%", "", model.InterestPolicy{APR: 3600, DayCount: model.DayCountActual365, Compounding: model.CompoundMonthly}))
+
+	projected, err := srv.ProjectInterest("u1")
+	if err != nil || projected.Amount != b.money("4.93") || projected.Month != "2026-02" {
+		t.Errorf("projected %+v, %v, want 4.93 for 2026-02", projected, err)
+	}
+
+	b.generate(srv, "2026-03-01T00:00:00Z")
+	b.generate(srv, "2026-03-01T00:00:00Z")
+
+	feb := b.statement(srv, "u1", "2026-02")
+	if feb.Interest != b.money("4.93") || feb.ClosingBalance != b.money("504.93") {
+		t.Errorf("february %+v, want 4.93 of interest closing at 504.93", feb)
+	}
+
+	charged, err := b.db.GetAll(model.InterestTransfer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(charged) != 1 {
+		t.Errorf("%d interest transfers, want 1", len(charged))
+	}
+}
+
+func TestMinimumDue(t *testing.T) {
+
+	b := newTestBook(t, "rate", "floor", "cap", "rounded", "credit")
+	srv := b.billing(terms(t, "0", "", "5%", "100", model.InterestPolicy{}))
+
+	b.purchase("rate", "3000.00")
+	b.purchase("floor", "1000.00")
+	b.purchase("cap", "40.00")
+	b.purchase("rounded", "2000.01")
+	b.purchase("credit", "40.00")
+	b.payback("credit", "40.00")
+
+	b.generate(srv, "2026-02-01T00:00:00Z")
+
+	want := map[string]model.Money{
+		"rate":    b.money("150.00"), //5% of 3000.00
+		"floor":   b.money("100.00"), //5% of 1000.00 is under the floor
+		"cap":     b.money("40.00"),  //the floor is over what the statement closed with
+		"rounded": b.money("100.01"), //5% of 2000.01 is 100.0005, rounded up
+		"credit":  0,                 //nothing to pay
+	}
+
+	for name, due := range want {
+		if got := b.statement(srv, name, "2026-01").MinimumDue; got != due {
+			t.Errorf("%s: minimum due %s, want %s", name, got, due)
+		}
+	}
+}
@@ -4,15 +4,23 @@ import (
 	"fmt"
 	"os"
 	"pay-later/integration/email"
+	"pay-later/integration/locale"
 	"pay-later/integration/log"
 	"pay-later/model"
+	"pay-later/service/billing"
+	"pay-later/service/fx"
+	"pay-later/service/ledger"
 	"pay-later/service/merchant"
 	"pay-later/service/report"
+	"pay-later/service/scheduler"
 	"pay-later/service/transaction"
 	"pay-later/service/transfer"
 	"pay-later/service/user"
-	"strconv"
+	"pay-later/service/verify"
 	"strings"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 const (
@@ -25,11 +33,25 @@ const (
 	CommandReportDues             = commandReportDues("report dues")
 	CommandReportCreditLimitUsers = commandReportCreditLimitUsers("report users-at-credit-limit")
 	CommandReportTotalDues        = commandReportTotalDues("report total-dues")
+	CommandReportTrialBalance     = commandReportTrialBalance("report trial-balance")
+	CommandReportOpenCharges      = commandReportOpenCharges("report open-charges")
+	CommandReportAvailableCredit  = commandReportAvailableCredit("report available-credit")
+	CommandRebuildMerchantTotals  = commandRebuildMerchantTotals("rebuild merchant-totals")
+	CommadDeleteUser              = commadDeleteUser("delete user")
+	CommadDeleteMerchant          = commadDeleteMerchant("delete merchant")
+	CommandClock                  = commandClock("clock")
+	CommandFX                     = commandFX("fx")
+	CommandVerifyLedger           = commandVerifyLedger("verify ledger")
+	CommandStatement              = commandStatement("statement")
+	CommandRefundCredit           = commandRefundCredit("credit refund")
+	CommandRefund                 = commandRefund("refund")
+	CommandDispute                = commandDispute("dispute")
+	CommandAuthorization          = commandAuthorization("auth")
 	CommandExit                   = commandExit("exit")
 )
 
 type CommandService interface {
-	Execute(l log.Logger)
+	Execute(l log.Logger, dbMan model.ModelManager)
 }
 
 func NewCommand(str string) (CommandService, error) {
@@ -70,6 +92,62 @@ func NewCommand(str string) (CommandService, error) {
 		return commandReportTotalDues(str), nil
 	}
 
+	if strings.HasPrefix(str, string(CommandReportOpenCharges)) {
+		return commandReportOpenCharges(str), nil
+	}
+
+	if strings.HasPrefix(str, string(CommandReportAvailableCredit)) {
+		return commandReportAvailableCredit(str), nil
+	}
+
+	if strings.HasPrefix(str, string(CommandReportTrialBalance)) {
+		return commandReportTrialBalance(str), nil
+	}
+
+	if strings.HasPrefix(str, string(CommandRebuildMerchantTotals)) {
+		return commandRebuildMerchantTotals(str), nil
+	}
+
+	if strings.HasPrefix(str, string(CommadDeleteUser)) {
+		return commadDeleteUser(str), nil
+	}
+
+	if strings.HasPrefix(str, string(CommadDeleteMerchant)) {
+		return commadDeleteMerchant(str), nil
+	}
+
+	if strings.HasPrefix(str, string(CommandClock)) {
+		return commandClock(str), nil
+	}
+
+	if strings.HasPrefix(str, string(CommandFX)) {
+		return commandFX(str), nil
+	}
+
+	if strings.HasPrefix(str, string(CommandVerifyLedger)) {
+		return commandVerifyLedger(str), nil
+	}
+
+	if strings.HasPrefix(str, string(CommandStatement)) {
+		return commandStatement(str), nil
+	}
+
+	if strings.HasPrefix(str, string(CommandRefundCredit)) {
+		return commandRefundCredit(str), nil
+	}
+
+	if strings.HasPrefix(str, string(CommandRefund)) {
+		return commandRefund(str), nil
+	}
+
+	if strings.HasPrefix(str, string(CommandDispute)) {
+		return commandDispute(str), nil
+	}
+
+	if strings.HasPrefix(str, string(CommandAuthorization)) {
+		return commandAuthorization(str), nil
+	}
+
 	if strings.HasPrefix(str, string(CommandExit)) {
 		return CommandExit, nil
 	}
@@ -80,9 +158,8 @@ func NewCommand(str string) (CommandService, error) {
 
 type commadCreateUser string
 
-func (c commadCreateUser) Execute(l log.Logger) {
+func (c commadCreateUser) Execute(l log.Logger, dbMan model.ModelManager) {
 
-	dbMan := model.NewModelManager(l)
 	emailSrv := email.NewEmailService(l)
 	usrSrv := user.NewUserService(dbMan, emailSrv, l)
 
@@ -92,27 +169,34 @@ func (c commadCreateUser) Execute(l log.Logger) {
 	email := parts[3]
 	creditLimitStr := parts[4]
 
-	creaditLimit, err := strconv.ParseFloat(creditLimitStr, 64)
+	currency := model.DefaultCurrency
+	if len(parts) > 5 {
+		var err error
+		currency, err = model.ParseCurrency(parts[5])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	creaditLimit, err := currency.ParseAmount(creditLimitStr)
 	if err != nil {
 		fmt.Println("invalid limit")
 		return
 	}
-	usr, err := usrSrv.CreateNewUser(name, email, creaditLimit)
+	usr, err := usrSrv.CreateNewUser(name, email, creaditLimit, currency)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
-	creditLimitInDollars := float64(usr.CreditLimit) / float64(100)
-
-	fmt.Println(fmt.Sprintf("%s(%0.2f)", usr.Name, creditLimitInDollars))
+	fmt.Println(fmt.Sprintf("%s(%s)", usr.Name, locale.Default().Format(usr.CreditLimit, usr.Currency)))
 }
 
 type commadCreateMerchant string
 
-func (c commadCreateMerchant) Execute(l log.Logger) {
+func (c commadCreateMerchant) Execute(l log.Logger, dbMan model.ModelManager) {
 
-	dbMan := model.NewModelManager(l)
 	emailSrv := email.NewEmailService(l)
 	mrtSrv := merchant.NewMerchantService(dbMan, emailSrv, l)
 
@@ -120,71 +204,115 @@ func (c commadCreateMerchant) Execute(l log.Logger) {
 
 	name := parts[2]
 	email := parts[3]
-	discountPercentStr := strings.TrimRight(parts[4], "%")
 
-	discountRate, err := strconv.ParseFloat(discountPercentStr, 64)
+	discountRate, err := model.ParseBasisPoints(parts[4])
 	if err != nil {
-		fmt.Println("invalid limit")
+		fmt.Println("invalid discount")
 		return
 	}
 
-	usr, err := mrtSrv.CreateNewMerchant(name, email, discountRate)
+	//the rounding policy and the currency are optional, in any order
+	var rounding model.RoundingPolicy
+	var currency model.Currency
+	for _, part := range parts[5:] {
+		if policy, perr := model.ParseRoundingPolicy(part); perr == nil {
+			rounding = policy
+			continue
+		}
+
+		currency, err = model.ParseCurrency(part)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("invalid rounding policy or currency %q", part))
+			return
+		}
+	}
+
+	usr, err := mrtSrv.CreateNewMerchant(name, email, discountRate, rounding, currency)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
-	discountInDollars := float64(usr.Discount) / float64(100)
-
-	fmt.Println(fmt.Sprintf("%s(%0.2f)", usr.Name, discountInDollars))
+	fmt.Println(fmt.Sprintf("%s(%s)", usr.Name, model.FormatBasisPoints(usr.Discount)))
 }
 
 type commadCreateTransaction string
 
-func (c commadCreateTransaction) Execute(l log.Logger) {
+func (c commadCreateTransaction) Execute(l log.Logger, dbMan model.ModelManager) {
 
-	dbMan := model.NewModelManager(l)
 	emailSrv := email.NewEmailService(l)
 	txnSrv := transaction.NewTransactionService(dbMan, l)
 	usrSrv := user.NewUserService(dbMan, emailSrv, l)
 	mrtSrv := merchant.NewMerchantService(dbMan, emailSrv, l)
-	transferSrv := transfer.NewTransferService(l, txnSrv, usrSrv, mrtSrv, dbMan)
+	fxSrv := fx.NewFXService(l, dbMan)
+	ledgerSrv := ledger.NewLedgerService(dbMan, l)
+	transferSrv := transfer.NewTransferService(l, txnSrv, usrSrv, mrtSrv, fxSrv, ledgerSrv, dbMan)
 
 	parts := strings.Split(string(c), " ")
 	uname := parts[2]
 	mname := parts[3]
 	amount := parts[4]
 
-	amountDollars, err := strconv.ParseFloat(amount, 64)
+	//the amount is in the merchant's currency
+	mrt, err := mrtSrv.GetMerchantWithName(mname)
 	if err != nil {
-		fmt.Println("invalid limit")
+		fmt.Println(err)
 		return
 	}
 
-	_, err = transferSrv.CreateInterTransfer(uname, mname, amountDollars)
+	amountDollars, err := mrt.Currency.ParseAmount(amount)
+	if err != nil {
+		fmt.Println("invalid amount")
+		return
+	}
+
+	var opts []transfer.TransferOption
+	if len(parts) > 5 && parts[5] == "fx" {
+		opts = append(opts, transfer.ConvertCurrency())
+	}
+
+	nTransfer, err := transferSrv.CreateInterTransfer(uname, mname, amountDollars, opts...)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
+	fmt.Println(fmt.Sprintf("transfer %s", nTransfer.ID))
 	fmt.Println("succcess!")
 }
 
 type commadUpdateMerchant string
 
-func (c commadUpdateMerchant) Execute(l log.Logger) {
+func (c commadUpdateMerchant) Execute(l log.Logger, dbMan model.ModelManager) {
 
-	dbMan := model.NewModelManager(l)
 	emailSrv := email.NewEmailService(l)
 	merchantSrv := merchant.NewMerchantService(dbMan, emailSrv, l)
 
 	parts := strings.Split(string(c), " ")
 	mname := parts[2]
-	disStr := strings.TrimRight(parts[3], "%")
 
-	discountRate, err := strconv.ParseFloat(disStr, 64)
+	if len(parts) == 5 && parts[3] == "rounding" {
+		rounding, err := model.ParseRoundingPolicy(parts[4])
+		if parts[4] == "default" {
+			rounding, err = "", nil
+		}
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		if _, err := merchantSrv.ChangeRoundingPolicy(mname, rounding); err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		fmt.Println("success!")
+		return
+	}
+
+	discountRate, err := model.ParseBasisPoints(parts[3])
 	if err != nil {
-		fmt.Println("invalid limit")
+		fmt.Println("invalid discount")
 		return
 	}
 
@@ -199,45 +327,71 @@ func (c commadUpdateMerchant) Execute(l log.Logger) {
 
 type commadPayback string
 
-func (c commadPayback) Execute(l log.Logger) {
+func (c commadPayback) Execute(l log.Logger, dbMan model.ModelManager) {
 
-	dbMan := model.NewModelManager(l)
 	emailSrv := email.NewEmailService(l)
 	txnSrv := transaction.NewTransactionService(dbMan, l)
 	usrSrv := user.NewUserService(dbMan, emailSrv, l)
 	mrtSrv := merchant.NewMerchantService(dbMan, emailSrv, l)
-	transferSrv := transfer.NewTransferService(l, txnSrv, usrSrv, mrtSrv, dbMan)
+	fxSrv := fx.NewFXService(l, dbMan)
+	ledgerSrv := ledger.NewLedgerService(dbMan, l)
+	transferSrv := transfer.NewTransferService(l, txnSrv, usrSrv, mrtSrv, fxSrv, ledgerSrv, dbMan)
 
 	parts := strings.Split(string(c), " ")
 	uname := parts[1]
 	amountStr := parts[2]
 
-	amount, err := strconv.ParseFloat(amountStr, 64)
+	usr, err := usrSrv.GetUserWithName(uname)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	amount, err := usr.Currency.ParseAmount(amountStr)
 	if err != nil {
 		fmt.Println("invalid amount")
 		return
 	}
 
-	_, err = transferSrv.CreatePaybackTransfer(uname, amount)
+	var opts []transfer.TransferOption
+	if len(parts) > 3 && parts[3] == "overpay" {
+		opts = append(opts, transfer.AcceptOverpayment())
+	}
+
+	payback, err := transferSrv.CreatePaybackTransfer(uname, amount, opts...)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	allocations, err := transferSrv.GetAllocations(payback.ID)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
+	loc := locale.Default()
+	for _, a := range allocations {
+		if a.ChargeID == uuid.Nil {
+			fmt.Println(fmt.Sprintf("%s: %s", a.Bucket, loc.Format(a.Amount, a.Currency)))
+			continue
+		}
+		fmt.Println(fmt.Sprintf("%s %s: %s", a.Bucket, a.ChargeID, loc.Format(a.Amount, a.Currency)))
+	}
+
 	fmt.Println("success!")
 }
 
 type commandReportDiscount string
 
-func (c commandReportDiscount) Execute(l log.Logger) {
+func (c commandReportDiscount) Execute(l log.Logger, dbMan model.ModelManager) {
 
-	dbMan := model.NewModelManager(l)
 	emailSrv := email.NewEmailService(l)
 	txnSrv := transaction.NewTransactionService(dbMan, l)
 	usrSrv := user.NewUserService(dbMan, emailSrv, l)
 	mrtSrv := merchant.NewMerchantService(dbMan, emailSrv, l)
 
-	rprtSrv := report.NewReportingService(l, txnSrv, usrSrv, mrtSrv, dbMan)
+	rprtSrv := report.NewReportingService(l, txnSrv, usrSrv, mrtSrv, dbMan, locale.Default())
 
 	parts := strings.Split(string(c), " ")
 	mname := parts[2]
@@ -253,14 +407,13 @@ func (c commandReportDiscount) Execute(l log.Logger) {
 
 type commandReportDues string
 
-func (c commandReportDues) Execute(l log.Logger) {
-	dbMan := model.NewModelManager(l)
+func (c commandReportDues) Execute(l log.Logger, dbMan model.ModelManager) {
 	emailSrv := email.NewEmailService(l)
 	txnSrv := transaction.NewTransactionService(dbMan, l)
 	usrSrv := user.NewUserService(dbMan, emailSrv, l)
 	mrtSrv := merchant.NewMerchantService(dbMan, emailSrv, l)
 
-	rprtSrv := report.NewReportingService(l, txnSrv, usrSrv, mrtSrv, dbMan)
+	rprtSrv := report.NewReportingService(l, txnSrv, usrSrv, mrtSrv, dbMan, locale.Default())
 
 	parts := strings.Split(string(c), " ")
 	uname := parts[2]
@@ -273,16 +426,39 @@ func (c commandReportDues) Execute(l log.Logger) {
 	fmt.Println(dis)
 }
 
+type commandReportAvailableCredit string
+
+func (c commandReportAvailableCredit) Execute(l log.Logger, dbMan model.ModelManager) {
+	emailSrv := email.NewEmailService(l)
+	txnSrv := transaction.NewTransactionService(dbMan, l)
+	usrSrv := user.NewUserService(dbMan, emailSrv, l)
+	mrtSrv := merchant.NewMerchantService(dbMan, emailSrv, l)
+
+	rprtSrv := report.NewReportingService(l, txnSrv, usrSrv, mrtSrv, dbMan, locale.Default())
+
+	parts := strings.Split(string(c), " ")
+	if len(parts) != 3 {
+		fmt.Println("usage: report available-credit <user>")
+		return
+	}
+
+	available, err := rprtSrv.GetAvailableCredit(parts[2])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(available)
+}
+
 type commandReportCreditLimitUsers string
 
-func (c commandReportCreditLimitUsers) Execute(l log.Logger) {
-	dbMan := model.NewModelManager(l)
+func (c commandReportCreditLimitUsers) Execute(l log.Logger, dbMan model.ModelManager) {
 	emailSrv := email.NewEmailService(l)
 	txnSrv := transaction.NewTransactionService(dbMan, l)
 	usrSrv := user.NewUserService(dbMan, emailSrv, l)
 	mrtSrv := merchant.NewMerchantService(dbMan, emailSrv, l)
 
-	rprtSrv := report.NewReportingService(l, txnSrv, usrSrv, mrtSrv, dbMan)
+	rprtSrv := report.NewReportingService(l, txnSrv, usrSrv, mrtSrv, dbMan, locale.Default())
 
 	users, err := rprtSrv.GetUsersAtCreditLimit()
 	if err != nil {
@@ -295,14 +471,13 @@ func (c commandReportCreditLimitUsers) Execute(l log.Logger) {
 
 type commandReportTotalDues string
 
-func (c commandReportTotalDues) Execute(l log.Logger) {
-	dbMan := model.NewModelManager(l)
+func (c commandReportTotalDues) Execute(l log.Logger, dbMan model.ModelManager) {
 	emailSrv := email.NewEmailService(l)
 	txnSrv := transaction.NewTransactionService(dbMan, l)
 	usrSrv := user.NewUserService(dbMan, emailSrv, l)
 	mrtSrv := merchant.NewMerchantService(dbMan, emailSrv, l)
 
-	rprtSrv := report.NewReportingService(l, txnSrv, usrSrv, mrtSrv, dbMan)
+	rprtSrv := report.NewReportingService(l, txnSrv, usrSrv, mrtSrv, dbMan, locale.Default())
 
 	str, err := rprtSrv.TotalDues()
 	if err != nil {
@@ -313,8 +488,690 @@ func (c commandReportTotalDues) Execute(l log.Logger) {
 	fmt.Println(str)
 }
 
+type commandReportOpenCharges string
+
+func (c commandReportOpenCharges) Execute(l log.Logger, dbMan model.ModelManager) {
+	emailSrv := email.NewEmailService(l)
+	txnSrv := transaction.NewTransactionService(dbMan, l)
+	usrSrv := user.NewUserService(dbMan, emailSrv, l)
+	mrtSrv := merchant.NewMerchantService(dbMan, emailSrv, l)
+	fxSrv := fx.NewFXService(l, dbMan)
+	ledgerSrv := ledger.NewLedgerService(dbMan, l)
+	transferSrv := transfer.NewTransferService(l, txnSrv, usrSrv, mrtSrv, fxSrv, ledgerSrv, dbMan)
+
+	parts := strings.Split(string(c), " ")
+	if len(parts) != 3 {
+		fmt.Println("usage: report open-charges <user>")
+		return
+	}
+
+	charges, err := transferSrv.GetOpenCharges(parts[2])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	loc := locale.Default()
+	for _, ch := range charges {
+		fmt.Println(fmt.Sprintf("%s %s %s: %s of %s open", ch.Bucket, ch.ChargeID, ch.PostedAt.Format("2006-01-02"),
+			loc.Format(ch.Open, ch.Currency), loc.Format(ch.Amount, ch.Currency)))
+	}
+
+	fmt.Println(fmt.Sprintf("%d open charges", len(charges)))
+}
+
+type commandReportTrialBalance string
+
+func (c commandReportTrialBalance) Execute(l log.Logger, dbMan model.ModelManager) {
+	emailSrv := email.NewEmailService(l)
+	txnSrv := transaction.NewTransactionService(dbMan, l)
+	usrSrv := user.NewUserService(dbMan, emailSrv, l)
+	mrtSrv := merchant.NewMerchantService(dbMan, emailSrv, l)
+
+	rprtSrv := report.NewReportingService(l, txnSrv, usrSrv, mrtSrv, dbMan, locale.Default())
+
+	str, err := rprtSrv.TrialBalance()
+	if str != "" {
+		fmt.Println(str)
+	}
+
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+type commandRebuildMerchantTotals string
+
+func (c commandRebuildMerchantTotals) Execute(l log.Logger, dbMan model.ModelManager) {
+	txnSrv := transaction.NewTransactionService(dbMan, l)
+
+	checks, err := txnSrv.RebuildMerchantTotals()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	for _, check := range checks {
+		if check.Matches() {
+			fmt.Println(fmt.Sprintf("%s: ok", check.Ledger.MerchantName))
+			continue
+		}
+
+		s, r := check.Stored, check.Ledger
+		loc, currency := locale.Default(), r.Currency
+		fmt.Println(fmt.Sprintf("%s: repaired discount %s -> %s, gross %s -> %s, net %s -> %s, transfers %d -> %d",
+			r.MerchantName,
+			loc.Format(s.DiscountEarned, currency), loc.Format(r.DiscountEarned, currency),
+			loc.Format(s.GrossVolume, currency), loc.Format(r.GrossVolume, currency),
+			loc.Format(s.NetPaid, currency), loc.Format(r.NetPaid, currency),
+			s.TransferCount, r.TransferCount))
+	}
+}
+
+type commadDeleteUser string
+
+func (c commadDeleteUser) Execute(l log.Logger, dbMan model.ModelManager) {
+	emailSrv := email.NewEmailService(l)
+	usrSrv := user.NewUserService(dbMan, emailSrv, l)
+
+	parts := strings.Split(string(c), " ")
+	if len(parts) != 3 {
+		fmt.Println("usage: delete user <name>")
+		return
+	}
+	name := parts[2]
+
+	if err := usrSrv.DeleteUser(name); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println("success!")
+}
+
+type commadDeleteMerchant string
+
+func (c commadDeleteMerchant) Execute(l log.Logger, dbMan model.ModelManager) {
+	emailSrv := email.NewEmailService(l)
+	mrtSrv := merchant.NewMerchantService(dbMan, emailSrv, l)
+
+	parts := strings.Split(string(c), " ")
+	if len(parts) != 3 {
+		fmt.Println("usage: delete merchant <name>")
+		return
+	}
+	name := parts[2]
+
+	if err := mrtSrv.DeleteMerchant(name); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println("success!")
+}
+
+// scheduledJobs are the time based jobs run for every day the simulated
+// clock is moved through, in order
+func scheduledJobs(l log.Logger, dbMan model.ModelManager) []scheduler.Job {
+	billingSrv := newBillingService(l, dbMan)
+
+	return []scheduler.Job{
+		billing.StatementJob{Billing: billingSrv},
+		billing.LateFeeJob{Billing: billingSrv},
+		transfer.ExpiryJob{Transfers: newTransferService(l, dbMan)},
+	}
+}
+
+func newTransferService(l log.Logger, dbMan model.ModelManager) transfer.TransferService {
+	emailSrv := email.NewEmailService(l)
+	txnSrv := transaction.NewTransactionService(dbMan, l)
+	usrSrv := user.NewUserService(dbMan, emailSrv, l)
+	mrtSrv := merchant.NewMerchantService(dbMan, emailSrv, l)
+
+	return transfer.NewTransferService(l, txnSrv, usrSrv, mrtSrv, fx.NewFXService(l, dbMan), ledger.NewLedgerService(dbMan, l), dbMan)
+}
+
+func newBillingService(l log.Logger, dbMan model.ModelManager) billing.BillingService {
+	usrSrv := user.NewUserService(dbMan, email.NewEmailService(l), l)
+	txnSrv := transaction.NewTransactionService(dbMan, l)
+
+	return billing.NewBillingService(l, usrSrv, txnSrv, ledger.NewLedgerService(dbMan, l), dbMan)
+}
+
+type commandClock string
+
+func (c commandClock) Execute(l log.Logger, dbMan model.ModelManager) {
+
+	parts := strings.Split(string(c), " ")
+
+	if len(parts) == 1 {
+		fmt.Println(dbMan.Clock().Now().Format(time.RFC3339))
+		return
+	}
+
+	clock, ok := dbMan.Clock().(*model.ManualClock)
+	if !ok {
+		fmt.Println("clock can not be moved, start with -simulated-clock")
+		return
+	}
+
+	schedulerSrv := scheduler.NewSchedulerService(l, clock, dbMan, scheduledJobs(l, dbMan)...)
+
+	var runs []scheduler.JobRun
+	var err error
+
+	switch {
+	case len(parts) == 3 && parts[1] == "set":
+		t, perr := scheduler.ParseTime(parts[2])
+		if perr != nil {
+			fmt.Println(perr)
+			return
+		}
+		runs, err = schedulerSrv.Set(t)
+
+	case len(parts) == 3 && parts[1] == "advance":
+		d, perr := scheduler.ParseDuration(parts[2])
+		if perr != nil {
+			fmt.Println(perr)
+			return
+		}
+		runs, err = schedulerSrv.Advance(d)
+
+	default:
+		fmt.Println("usage: clock [set <date> | advance <duration>]")
+		return
+	}
+
+	for _, run := range runs {
+		if run.Err == nil {
+			fmt.Println(fmt.Sprintf("%s %s: ok", run.Day.Format("2006-01-02"), run.Job))
+		}
+	}
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	fmt.Println(schedulerSrv.Now().Format(time.RFC3339))
+}
+
+type commandFX string
+
+func (c commandFX) Execute(l log.Logger, dbMan model.ModelManager) {
+
+	fxSrv := fx.NewFXService(l, dbMan)
+
+	parts := strings.Split(string(c), " ")
+
+	switch {
+	case len(parts) == 1:
+		rates, err := fxSrv.GetRates()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		for _, rate := range rates {
+			fmt.Println(rate)
+		}
+
+	case len(parts) == 3 && parts[1] == "load":
+		f, err := os.Open(parts[2])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer f.Close()
+
+		rates, err := fxSrv.LoadRates(f)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		fmt.Println(fmt.Sprintf("loaded %d rates", len(rates)))
+
+	default:
+		fmt.Println("usage: fx [load <path>]")
+	}
+}
+
+type commandVerifyLedger string
+
+func (c commandVerifyLedger) Execute(l log.Logger, dbMan model.ModelManager) {
+	txnSrv := transaction.NewTransactionService(dbMan, l)
+	ledgerSrv := ledger.NewLedgerService(dbMan, l)
+	verifySrv := verify.NewVerifyService(l, txnSrv, ledgerSrv, dbMan)
+
+	parts := strings.Split(string(c), " ")
+	repair := len(parts) > 2 && parts[2] == "repair"
+
+	report, err := verifySrv.VerifyLedger(repair)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	loc := locale.Default()
+	repaired := 0
+
+	for _, m := range report.Mismatches {
+		ids := make([]string, 0, len(m.TransferIDs))
+		for _, id := range m.TransferIDs {
+			ids = append(ids, id.String())
+		}
+
+		line := fmt.Sprintf("%s %s: expected %s, found %s, transfers [%s]",
+			m.Kind, m.Name, loc.Format(m.Expected, m.Currency), loc.Format(m.Actual, m.Currency), strings.Join(ids, " "))
+		if m.Repaired {
+			line += " repaired"
+			repaired++
+		}
+		fmt.Println(line)
+	}
+
+	summary := fmt.Sprintf("checked %d users, %d merchants, %d transfers: ", report.Users, report.Merchants, report.Transfers)
+	if len(report.Mismatches) == 0 {
+		fmt.Println(summary + "ok")
+		return
+	}
+
+	fmt.Println(summary + fmt.Sprintf("%d mismatches, %d repaired", len(report.Mismatches), repaired))
+}
+
+type commandStatement string
+
+func (c commandStatement) Execute(l log.Logger, dbMan model.ModelManager) {
+	billingSrv := newBillingService(l, dbMan)
+
+	parts := strings.Split(string(c), " ")
+	loc := locale.Default()
+
+	switch {
+	case len(parts) == 2 && parts[1] == "generate":
+		statements, err := billingSrv.GenerateStatements(dbMan.Clock().Now())
+		for _, s := range statements {
+			fmt.Println(fmt.Sprintf("%s %s: %s", s.UserName, s.Month, loc.Format(s.ClosingBalance, s.Currency)))
+		}
+
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		fmt.Println(fmt.Sprintf("generated %d statements", len(statements)))
+
+	case len(parts) == 4 && parts[1] == "show":
+		s, err := billingSrv.GetStatement(parts[2], parts[3])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		fmt.Println(fmt.Sprintf("statement %s %s, %s to %s", s.UserName, s.Month,
+			s.CycleStart.Format("2006-01-02"), s.CycleEnd.AddDate(0, 0, -1).Format("2006-01-02")))
+		fmt.Println(fmt.Sprintf("opening balance: %s", loc.Format(s.OpeningBalance, s.Currency)))
+		fmt.Println(fmt.Sprintf("purchases: %s", loc.Format(s.Purchases, s.Currency)))
+		fmt.Println(fmt.Sprintf("paybacks: %s", loc.Format(s.Paybacks, s.Currency)))
+		fmt.Println(fmt.Sprintf("fees: %s", loc.Format(s.Fees, s.Currency)))
+		fmt.Println(fmt.Sprintf("interest: %s", loc.Format(s.Interest, s.Currency)))
+		fmt.Println(fmt.Sprintf("adjustments: %s", loc.Format(s.Adjustments, s.Currency)))
+		fmt.Println(fmt.Sprintf("closing dues: %s", loc.Format(s.ClosingBalance, s.Currency)))
+		fmt.Println(fmt.Sprintf("minimum due: %s", loc.Format(s.MinimumDue, s.Currency)))
+		if !s.DueDate.IsZero() {
+			fmt.Println(fmt.Sprintf("due by: %s", s.DueDate.Format("2006-01-02")))
+		}
+
+	case len(parts) == 2 && parts[1] == "late-fees":
+		fees, err := billingSrv.ChargeLateFees(dbMan.Clock().Now())
+		for _, f := range fees {
+			fmt.Println(fmt.Sprintf("%s %s: %s", f.UserName, f.Month, loc.Format(f.Amount, f.Currency)))
+		}
+
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		fmt.Println(fmt.Sprintf("charged %d late fees", len(fees)))
+
+	case len(parts) == 3 && parts[1] == "interest":
+		interest, err := billingSrv.ProjectInterest(parts[2])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		fmt.Println(fmt.Sprintf("%s %s: projected interest %s at %s%% apr, %s, compounded %s", interest.UserName, interest.Month,
+			loc.Format(interest.Amount, interest.Currency), model.FormatBasisPoints(interest.APR), interest.DayCount, interest.Compounding))
+
+	default:
+		fmt.Println("usage: statement generate | statement late-fees | statement interest <user> | statement show <user> <month>")
+	}
+}
+
+type commandRefundCredit string
+
+func (c commandRefundCredit) Execute(l log.Logger, dbMan model.ModelManager) {
+	emailSrv := email.NewEmailService(l)
+	txnSrv := transaction.NewTransactionService(dbMan, l)
+	usrSrv := user.NewUserService(dbMan, emailSrv, l)
+	mrtSrv := merchant.NewMerchantService(dbMan, emailSrv, l)
+	fxSrv := fx.NewFXService(l, dbMan)
+	ledgerSrv := ledger.NewLedgerService(dbMan, l)
+	transferSrv := transfer.NewTransferService(l, txnSrv, usrSrv, mrtSrv, fxSrv, ledgerSrv, dbMan)
+
+	parts := strings.Split(string(c), " ")
+	if len(parts) < 3 || len(parts) > 4 {
+		fmt.Println("usage: credit refund <user> [amount]")
+		return
+	}
+
+	usr, err := usrSrv.GetUserWithName(parts[2])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	var amount model.Money
+	if len(parts) == 4 {
+		if amount, err = usr.Currency.ParseAmount(parts[3]); err != nil {
+			fmt.Println("invalid amount")
+			return
+		}
+	}
+
+	refund, err := transferSrv.RefundCredit(usr.Name, amount)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println(fmt.Sprintf("refunded %s to %s", locale.Default().Format(refund.Amount, refund.Currency), refund.PaybackID))
+}
+
+type commandRefund string
+
+func (c commandRefund) Execute(l log.Logger, dbMan model.ModelManager) {
+	emailSrv := email.NewEmailService(l)
+	txnSrv := transaction.NewTransactionService(dbMan, l)
+	usrSrv := user.NewUserService(dbMan, emailSrv, l)
+	mrtSrv := merchant.NewMerchantService(dbMan, emailSrv, l)
+	fxSrv := fx.NewFXService(l, dbMan)
+	ledgerSrv := ledger.NewLedgerService(dbMan, l)
+	transferSrv := transfer.NewTransferService(l, txnSrv, usrSrv, mrtSrv, fxSrv, ledgerSrv, dbMan)
+
+	parts := strings.Split(string(c), " ")
+	if len(parts) < 2 || len(parts) > 3 {
+		fmt.Println("usage: refund <transferID> [amount]")
+		return
+	}
+
+	transferID, err := uuid.Parse(parts[1])
+	if err != nil {
+		fmt.Println("invalid transfer id")
+		return
+	}
+
+	original, err := transferSrv.GetInterTransfer(transferID)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	//the amount is of the sale, in the merchant's currency
+	var amount model.Money
+	if len(parts) == 3 {
+		if amount, err = original.MerchantCurrency.OrDefault().ParseAmount(parts[2]); err != nil {
+			fmt.Println("invalid amount")
+			return
+		}
+	}
+
+	refund, err := transferSrv.RefundInterTransfer(original.ID, amount)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	loc := locale.Default()
+	fmt.Println(fmt.Sprintf("refunded %s to %s, discount of %s clawed back from %s",
+		loc.Format(refund.Amount, refund.Currency), refund.UserName,
+		loc.Format(refund.DiscountAmount, refund.MerchantCurrency), refund.MerchantName))
+}
+
+type commandDispute string
+
+func (c commandDispute) Execute(l log.Logger, dbMan model.ModelManager) {
+	emailSrv := email.NewEmailService(l)
+	txnSrv := transaction.NewTransactionService(dbMan, l)
+	usrSrv := user.NewUserService(dbMan, emailSrv, l)
+	mrtSrv := merchant.NewMerchantService(dbMan, emailSrv, l)
+	fxSrv := fx.NewFXService(l, dbMan)
+	ledgerSrv := ledger.NewLedgerService(dbMan, l)
+	transferSrv := transfer.NewTransferService(l, txnSrv, usrSrv, mrtSrv, fxSrv, ledgerSrv, dbMan)
+
+	usage := "usage: dispute open <transferID> <amount|all> [reason] | dispute review <disputeID> | dispute resolve <disputeID> user|merchant | dispute show <disputeID> | dispute list <user>"
+
+	parts := strings.Split(string(c), " ")
+	if len(parts) < 3 {
+		fmt.Println(usage)
+		return
+	}
+
+	if parts[1] == "list" {
+		disputes, err := transferSrv.GetDisputes(parts[2])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		for _, d := range disputes {
+			printDispute(d)
+		}
+		fmt.Println(fmt.Sprintf("%d disputes", len(disputes)))
+		return
+	}
+
+	id, err := uuid.Parse(parts[2])
+	if err != nil {
+		fmt.Println("invalid id")
+		return
+	}
+
+	var dispute *model.Dispute
+
+	switch {
+	case parts[1] == "open" && len(parts) >= 4:
+		var original *model.InterTransfer
+		if original, err = transferSrv.GetInterTransfer(id); err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		//the amount, of the sale in the merchant's currency, is always given so
+		//that a reason starting with a number is not taken for one
+		var amount model.Money
+		if parts[3] != "all" {
+			if amount, err = original.MerchantCurrency.OrDefault().ParseAmount(parts[3]); err != nil {
+				fmt.Println("invalid amount")
+				return
+			}
+
+			if amount == 0 {
+				fmt.Println("amount should be greater than zero")
+				return
+			}
+		}
+
+		dispute, err = transferSrv.OpenDispute(original.ID, amount, strings.Join(parts[4:], " "))
+
+	case parts[1] == "review" && len(parts) == 3:
+		dispute, err = transferSrv.ReviewDispute(id)
+
+	case parts[1] == "resolve" && len(parts) == 4 && (parts[3] == "user" || parts[3] == "merchant"):
+		dispute, err = transferSrv.ResolveDispute(id, parts[3] == "user")
+
+	case parts[1] == "show" && len(parts) == 3:
+		dispute, err = transferSrv.GetDispute(id)
+
+	default:
+		fmt.Println(usage)
+		return
+	}
+
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	printDispute(*dispute)
+}
+
+func printDispute(d model.Dispute) {
+	loc := locale.Default()
+
+	line := fmt.Sprintf("dispute %s of transfer %s, %s against %s: %s %s, provisional credit %s", d.ID, d.TransferID, d.UserName, d.MerchantName,
+		loc.Format(d.Amount, d.MerchantCurrency), d.State, loc.Format(d.ProvisionalCredit, d.Currency))
+	if d.Reason != "" {
+		line += fmt.Sprintf(" (%s)", d.Reason)
+	}
+
+	fmt.Println(line)
+}
+
+type commandAuthorization string
+
+func (c commandAuthorization) Execute(l log.Logger, dbMan model.ModelManager) {
+	transferSrv := newTransferService(l, dbMan)
+
+	usage := "usage: auth hold <user> <merchant> <amount> [fx] | auth capture <authID> [amount] | auth void <authID> | auth show <authID> | auth list <user>"
+
+	parts := strings.Split(string(c), " ")
+	if len(parts) < 3 {
+		fmt.Println(usage)
+		return
+	}
+
+	switch parts[1] {
+	case "hold":
+		if len(parts) < 5 {
+			fmt.Println(usage)
+			return
+		}
+
+		//the amount is in the merchant's currency
+		mrt, err := merchant.NewMerchantService(dbMan, email.NewEmailService(l), l).GetMerchantWithName(parts[3])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		amount, err := mrt.Currency.ParseAmount(parts[4])
+		if err != nil {
+			fmt.Println("invalid amount")
+			return
+		}
+
+		var opts []transfer.TransferOption
+		if len(parts) > 5 && parts[5] == "fx" {
+			opts = append(opts, transfer.ConvertCurrency())
+		}
+
+		auth, err := transferSrv.Authorize(parts[2], mrt.Name, amount, opts...)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		printAuthorization(*auth)
+		return
+
+	case "list":
+		auths, err := transferSrv.GetAuthorizations(parts[2])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		for _, a := range auths {
+			printAuthorization(a)
+		}
+		fmt.Println(fmt.Sprintf("%d authorizations", len(auths)))
+		return
+	}
+
+	id, err := uuid.Parse(parts[2])
+	if err != nil {
+		fmt.Println("invalid id")
+		return
+	}
+
+	var auth *model.Authorization
+
+	switch {
+	case parts[1] == "capture" && len(parts) <= 4:
+		if auth, err = transferSrv.GetAuthorization(id); err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		//the amount is of the sale, in the merchant's currency
+		var amount model.Money
+		if len(parts) == 4 {
+			if amount, err = auth.MerchantCurrency.OrDefault().ParseAmount(parts[3]); err != nil {
+				fmt.Println("invalid amount")
+				return
+			}
+		}
+
+		var nTransfer *model.InterTransfer
+		if nTransfer, err = transferSrv.CaptureAuthorization(auth.ID, amount); err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		fmt.Println(fmt.Sprintf("transfer %s", nTransfer.ID))
+		auth, err = transferSrv.GetAuthorization(auth.ID)
+
+	case parts[1] == "void" && len(parts) == 3:
+		auth, err = transferSrv.VoidAuthorization(id)
+
+	case parts[1] == "show" && len(parts) == 3:
+		auth, err = transferSrv.GetAuthorization(id)
+
+	default:
+		fmt.Println(usage)
+		return
+	}
+
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	printAuthorization(*auth)
+}
+
+func printAuthorization(a model.Authorization) {
+	loc := locale.Default()
+
+	line := fmt.Sprintf("authorization %s, %s at %s: %s %s", a.ID, a.UserName, a.MerchantName, loc.Format(a.MerchantAmount, a.MerchantCurrency), a.State)
+
+	switch a.State {
+	case model.AUTHORIZATION_PENDING:
+		line += fmt.Sprintf(", holding %s until %s", loc.Format(a.Amount, a.Currency), a.ExpiresAt.Format(time.RFC3339))
+	case model.AUTHORIZATION_CAPTURED:
+		line += fmt.Sprintf(" %s as transfer %s", loc.Format(a.CapturedAmount, a.MerchantCurrency), a.TransferID)
+	default:
+		line += fmt.Sprintf(" at %s", a.ClosedAt.Format(time.RFC3339))
+	}
+
+	fmt.Println(line)
+}
+
 type commandExit string
 
-func (c commandExit) Execute(l log.Logger) {
+func (c commandExit) Execute(l log.Logger, dbMan model.ModelManager) {
 	os.Exit(0)
 }
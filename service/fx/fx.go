@@ -0,0 +1,144 @@
+package fx
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"pay-later/integration/log"
+	"pay-later/model"
+	"strings"
+)
+
+type FXService interface {
+	LoadRates(io.Reader) ([]model.FXRate, error)
+	GetRate(model.Currency, model.Currency) (*model.FXRate, error)
+	GetRates() ([]model.FXRate, error)
+}
+
+type fxService struct {
+	l     log.Logger
+	dbSrv model.ModelManager
+}
+
+func NewFXService(l log.Logger, dbSrv model.ModelManager) FXService {
+	return &fxService{
+		l, dbSrv,
+	}
+}
+
+// LoadRates reads a rate table with one "FROM,TO,RATE" line per pair, such as
+// "USD,INR,83.25", and stores every rate of it or none. blank lines and lines
+// starting with # are skipped, and a pair already stored is replaced.
+func (f fxService) LoadRates(r io.Reader) ([]model.FXRate, error) {
+
+	var rates []model.FXRate
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		parts := strings.Split(text, ",")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("line %d: expected FROM,TO,RATE", line)
+		}
+
+		from, err := model.ParseCurrency(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		to, err := model.ParseCurrency(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		if from == to {
+			return nil, fmt.Errorf("line %d: rate from %s to itself", line, from)
+		}
+
+		rate, err := model.ParseFXRate(strings.TrimSpace(parts[2]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		rates = append(rates, model.FXRate{From: from, To: to, Rate: rate})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	tx, err := f.dbSrv.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for i, rate := range rates {
+		stored, found, err := tx.GetWithPrimaryKey(rate)
+		if err != nil {
+			return nil, err
+		}
+
+		if found {
+			rate.Version = stored.(model.FXRate).Version
+		}
+
+		nModel, err := tx.Upsert(rate)
+		if err != nil {
+			f.l.ErrorD("can not able to store fx rate", log.Fields{"rate": rate})
+			return nil, err
+		}
+
+		rates[i] = nModel.(model.FXRate)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return rates, nil
+}
+
+// GetRate is the loaded rate converting from into to
+func (f fxService) GetRate(from model.Currency, to model.Currency) (*model.FXRate, error) {
+
+	rModel, found, err := f.dbSrv.GetWithPrimaryKey(model.FXRate{From: from, To: to})
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, fmt.Errorf("no fx rate from %s to %s", from, to)
+	}
+
+	rate, ok := rModel.(model.FXRate)
+	if !ok {
+		return nil, fmt.Errorf("can not able to type assert model")
+	}
+
+	return &rate, nil
+}
+
+func (f fxService) GetRates() ([]model.FXRate, error) {
+
+	models, err := f.dbSrv.Query(model.FXRate{}, model.NewQuery())
+	if err != nil {
+		return nil, err
+	}
+
+	var resp = make([]model.FXRate, 0, len(models))
+	for _, m := range models {
+		rate, ok := m.(model.FXRate)
+		if !ok {
+			return nil, fmt.Errorf("can not able to type assert model")
+		}
+		resp = append(resp, rate)
+	}
+
+	return resp, nil
+}
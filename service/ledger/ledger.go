@@ -0,0 +1,273 @@
+package ledger
+
+import (
+	"fmt"
+	"pay-later/integration/log"
+	"pay-later/model"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type LedgerService interface {
+	PostEntry(model.JournalEntry, ...model.JournalLine) (*model.JournalEntry, error)
+	GetBalance(model.Account) (*model.Balance, error)
+	GetBalances() ([]model.Balance, error)
+	GetUserDues(string, model.Currency) (model.Money, error)
+	GetEntries(uuid.UUID) ([]model.JournalEntry, error)
+	GetLines(uuid.UUID) ([]model.JournalLine, error)
+	GetEntry(uuid.UUID) (*model.JournalEntry, error)
+	GetAccountLines(model.Account, time.Time, time.Time) ([]model.JournalLine, error)
+	WithModelManager(model.ModelManager) LedgerService
+}
+
+type ledgerService struct {
+	l  log.Logger
+	db model.ModelManager
+}
+
+func NewLedgerService(db model.ModelManager, log log.Logger) LedgerService {
+	return &ledgerService{
+		log, db,
+	}
+}
+
+// WithModelManager returns a copy of the service working on db, typically a
+// model.Tx so that its postings join a unit of work
+func (s ledgerService) WithModelManager(db model.ModelManager) LedgerService {
+	s.db = db
+	return &s
+}
+
+// PostEntry writes a balanced entry with its lines, in one unit of work.
+// lines of a zero amount are left out, and the entry takes the transfer and
+// posting time of the entry.
+func (s ledgerService) PostEntry(entry model.JournalEntry, lines ...model.JournalLine) (*model.JournalEntry, error) {
+
+	var posted = make([]model.JournalLine, 0, len(lines))
+	for _, line := range lines {
+		if line.Debit != 0 || line.Credit != 0 {
+			posted = append(posted, line)
+		}
+	}
+
+	if err := model.ValidateEntry(entry, posted); err != nil {
+		s.l.ErrorD("invalid journal entry", log.Fields{"entry": entry, "lines": lines})
+		return nil, err
+	}
+
+	db := s.db
+	tx, inTx := db.(model.Tx)
+	if !inTx {
+		var err error
+		if tx, err = db.Begin(); err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+		db = tx
+	}
+
+	if entry.ID == uuid.Nil {
+		id, err := uuid.NewUUID()
+		if err != nil {
+			return nil, fmt.Errorf("can not able to generate journal entry id")
+		}
+		entry.ID = id
+	}
+
+	eModel, err := db.Upsert(entry)
+	if err != nil {
+		s.l.ErrorD("can not able to write journal entry", log.Fields{"entry": entry})
+		return nil, err
+	}
+
+	nEntry := eModel.(model.JournalEntry)
+
+	for _, line := range posted {
+		id, err := uuid.NewUUID()
+		if err != nil {
+			return nil, fmt.Errorf("can not able to generate journal line id")
+		}
+
+		line.ID = id
+		line.EntryID = nEntry.ID
+		line.TransferID = nEntry.TransferID
+		line.PostedAt = nEntry.PostedAt
+
+		if _, err := db.Upsert(line); err != nil {
+			s.l.ErrorD("can not able to write journal line", log.Fields{"line": line})
+			return nil, err
+		}
+	}
+
+	if !inTx {
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &nEntry, nil
+}
+
+// GetBalance sums every line posted to the account
+func (s ledgerService) GetBalance(account model.Account) (*model.Balance, error) {
+
+	lines, err := s.db.Query(model.JournalLine{}, model.NewQuery().Where("AccountID", model.Eq, account.ID()))
+	if err != nil {
+		return nil, err
+	}
+
+	balance := model.Balance{Account: account}
+	for _, m := range lines {
+		line, ok := m.(model.JournalLine)
+		if !ok {
+			return nil, fmt.Errorf("can not able to type assert journal line")
+		}
+
+		if err := balance.Post(line); err != nil {
+			return nil, err
+		}
+	}
+
+	return &balance, nil
+}
+
+// GetBalances is the balance of every account with a posting, ordered by
+// account ID
+func (s ledgerService) GetBalances() ([]model.Balance, error) {
+
+	lines, err := s.db.GetAll(model.JournalLine{})
+	if err != nil {
+		return nil, err
+	}
+
+	var balances = make(map[string]*model.Balance)
+	for _, m := range lines {
+		line, ok := m.(model.JournalLine)
+		if !ok {
+			return nil, fmt.Errorf("can not able to type assert journal line")
+		}
+
+		balance, ok := balances[line.AccountID]
+		if !ok {
+			account, err := model.ParseAccountID(line.AccountID)
+			if err != nil {
+				return nil, err
+			}
+			balance = &model.Balance{Account: account}
+			balances[line.AccountID] = balance
+		}
+
+		if err := balance.Post(line); err != nil {
+			return nil, err
+		}
+	}
+
+	var resp = make([]model.Balance, 0, len(balances))
+	for _, balance := range balances {
+		resp = append(resp, *balance)
+	}
+
+	sort.Slice(resp, func(i, j int) bool {
+		return resp[i].Account.ID() < resp[j].Account.ID()
+	})
+
+	return resp, nil
+}
+
+// GetUserDues is the balance of the user's receivable in currency
+func (s ledgerService) GetUserDues(userName string, currency model.Currency) (model.Money, error) {
+
+	balance, err := s.GetBalance(model.UserReceivable(userName, currency))
+	if err != nil {
+		return 0, err
+	}
+
+	return balance.Net()
+}
+
+// GetEntries are the journal entries of a transfer, oldest first
+func (s ledgerService) GetEntries(transferID uuid.UUID) ([]model.JournalEntry, error) {
+
+	models, err := s.db.Query(model.JournalEntry{}, model.NewQuery().Where("TransferID", model.Eq, transferID).OrderBy("PostedAt", false))
+	if err != nil {
+		return nil, err
+	}
+
+	var resp = make([]model.JournalEntry, 0, len(models))
+	for _, m := range models {
+		entry, ok := m.(model.JournalEntry)
+		if !ok {
+			return nil, fmt.Errorf("can not able to type assert journal entry")
+		}
+		resp = append(resp, entry)
+	}
+
+	return resp, nil
+}
+
+// GetLines are the journal lines of a transfer
+func (s ledgerService) GetLines(transferID uuid.UUID) ([]model.JournalLine, error) {
+
+	models, err := s.db.Query(model.JournalLine{}, model.NewQuery().Where("TransferID", model.Eq, transferID).OrderBy("PostedAt", false))
+	if err != nil {
+		return nil, err
+	}
+
+	var resp = make([]model.JournalLine, 0, len(models))
+	for _, m := range models {
+		line, ok := m.(model.JournalLine)
+		if !ok {
+			return nil, fmt.Errorf("can not able to type assert journal line")
+		}
+		resp = append(resp, line)
+	}
+
+	return resp, nil
+}
+
+func (s ledgerService) GetEntry(id uuid.UUID) (*model.JournalEntry, error) {
+
+	eModel, found, err := s.db.GetWithPrimaryKey(model.JournalEntry{ID: id})
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, fmt.Errorf("journal entry %s not found", id)
+	}
+
+	entry, ok := eModel.(model.JournalEntry)
+	if !ok {
+		return nil, fmt.Errorf("can not able to type assert journal entry")
+	}
+
+	return &entry, nil
+}
+
+// GetAccountLines are the lines posted to the account from, inclusive, to,
+// exclusive, oldest first. a zero from has no lower bound.
+func (s ledgerService) GetAccountLines(account model.Account, from time.Time, to time.Time) ([]model.JournalLine, error) {
+
+	q := model.NewQuery().Where("AccountID", model.Eq, account.ID()).Where("PostedAt", model.Lt, to).OrderBy("PostedAt", false)
+	if !from.IsZero() {
+		q = q.Where("PostedAt", model.Gte, from)
+	}
+
+	models, err := s.db.Query(model.JournalLine{}, q)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp = make([]model.JournalLine, 0, len(models))
+	for _, m := range models {
+		line, ok := m.(model.JournalLine)
+		if !ok {
+			return nil, fmt.Errorf("can not able to type assert journal line")
+		}
+		resp = append(resp, line)
+	}
+
+	return resp, nil
+}
@@ -3,34 +3,96 @@ package merchant
 import (
 	"fmt"
 	"pay-later/integration/email"
+	"pay-later/integration/lock"
 	"pay-later/integration/log"
 	"pay-later/model"
+	"pay-later/service/ledger"
 )
 
 type MerchantService interface {
-	ChangeDiscountRate(string, float64) (*model.Merchant, error)
+	ChangeDiscountRate(string, int) (*model.Merchant, error)
 	GetMerchantWithName(string) (*model.Merchant, error)
-	CreateNewMerchant(string, string, float64) (*model.Merchant, error)
+	ChangeRoundingPolicy(string, model.RoundingPolicy) (*model.Merchant, error)
+	CreateNewMerchant(string, string, int, model.RoundingPolicy, model.Currency) (*model.Merchant, error)
+	LockMerchant(string) func()
+	DeleteMerchant(string) error
 }
 
+// merchantLocks serializes the writes to the running totals of a merchant,
+// shared by every service instance like userLocks in the user service
+var merchantLocks = lock.NewKeyLocks()
+
+// maxConflictRetries is how many times a write that only sets a value is
+// re-read and retried after losing an optimistic concurrency race
+const maxConflictRetries = 3
+
 type merchantService struct {
-	dbSrv   model.ModelManager
-	mailSrv email.EmailService
-	l       log.Logger
+	dbSrv     model.ModelManager
+	mailSrv   email.EmailService
+	l         log.Logger
+	ledgerSrv ledger.LedgerService
 }
 
 func NewMerchantService(db model.ModelManager, email email.EmailService, log log.Logger) MerchantService {
 	return &merchantService{
-		db, email, log,
+		db, email, log, ledger.NewLedgerService(db, log),
 	}
 }
 
-func (u merchantService) ChangeDiscountRate(businessName string, limit float64) (*model.Merchant, error) {
+// LockMerchant blocks until the caller is the only one posting transfers to
+// the merchant, and returns the function releasing it. it is not reentrant.
+func (u merchantService) LockMerchant(name string) func() {
+	return merchantLocks.Lock(name)
+}
+
+// ChangeDiscountRate sets the discount of the merchant, in basis points
+func (u merchantService) ChangeDiscountRate(businessName string, limit int) (*model.Merchant, error) {
 
-	if limit < 0 || limit > 100 {
+	if limit < 0 || limit > 10000 {
 		return nil, fmt.Errorf("invalid limit")
 	}
 
+	for attempt := 0; ; attempt++ {
+		nMerchant, err := u.changeDiscountRate(businessName, limit)
+		if model.IsVersionConflict(err) && attempt < maxConflictRetries {
+			u.l.WarnD("retrying discount rate change after conflict", log.Fields{"merchant": businessName, "attempt": attempt})
+			continue
+		}
+		return nMerchant, err
+	}
+}
+
+func (u merchantService) changeDiscountRate(businessName string, limit int) (*model.Merchant, error) {
+	return u.updateMerchant(businessName, func(merchant *model.Merchant) {
+		merchant.Discount = limit
+	})
+}
+
+// ChangeRoundingPolicy sets the policy the discounts of the merchant are
+// rounded by, empty to follow the default one
+func (u merchantService) ChangeRoundingPolicy(businessName string, rounding model.RoundingPolicy) (*model.Merchant, error) {
+
+	if rounding != "" {
+		if err := rounding.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		nMerchant, err := u.updateMerchant(businessName, func(merchant *model.Merchant) {
+			merchant.Rounding = rounding
+		})
+		if model.IsVersionConflict(err) && attempt < maxConflictRetries {
+			u.l.WarnD("retrying rounding policy change after conflict", log.Fields{"merchant": businessName, "attempt": attempt})
+			continue
+		}
+		return nMerchant, err
+	}
+}
+
+// updateMerchant reads the merchant, applies set to it and writes it back
+func (u merchantService) updateMerchant(businessName string, set func(*model.Merchant)) (*model.Merchant, error) {
+
 	usr := model.Merchant{
 		Name: businessName,
 	}
@@ -49,7 +111,7 @@ func (u merchantService) ChangeDiscountRate(businessName string, limit float64)
 		return nil, fmt.Errorf("can not able to type assert model")
 	}
 
-	merchant.Discount = getDiscountRate(limit)
+	set(&merchant)
 
 	nModel, err := u.dbSrv.Upsert(merchant)
 	if err != nil {
@@ -65,12 +127,25 @@ func (u merchantService) ChangeDiscountRate(businessName string, limit float64)
 	return &nUser, nil
 }
 
-func (u merchantService) CreateNewMerchant(name string, mail string, limit float64) (*model.Merchant, error) {
+// CreateNewMerchant creates a merchant with a discount in basis points, the
+// policy it is rounded by and the currency it is settled in, either empty for
+// the default one
+func (u merchantService) CreateNewMerchant(name string, mail string, limit int, rounding model.RoundingPolicy, currency model.Currency) (*model.Merchant, error) {
 
 	if !u.mailSrv.IsValid(mail) {
 		return nil, fmt.Errorf("invalid mail")
 	}
 
+	if limit < 0 || limit > 10000 {
+		return nil, fmt.Errorf("invalid limit")
+	}
+
+	if rounding != "" {
+		if err := rounding.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
 	nMerchant := model.Merchant{
 		Name:  name,
 		Email: mail,
@@ -85,7 +160,9 @@ func (u merchantService) CreateNewMerchant(name string, mail string, limit float
 		return nil, fmt.Errorf("merchant already exist")
 	}
 
-	nMerchant.Discount = getDiscountRate(limit)
+	nMerchant.Discount = limit
+	nMerchant.Rounding = rounding
+	nMerchant.Currency = currency.OrDefault()
 
 	uModel, err := u.dbSrv.Upsert(nMerchant)
 	if err != nil {
@@ -125,6 +202,55 @@ func (u merchantService) GetMerchantWithName(name string) (*model.Merchant, erro
 	return &nUser, nil
 }
 
-func getDiscountRate(rate float64) int {
-	return int(rate * 100)
+// DeleteMerchant soft deletes a merchant, keeping the name reserved since the
+// ledger still refers to it. a merchant still owed money or holding credit of
+// users is not deleted.
+func (u merchantService) DeleteMerchant(name string) error {
+
+	unlock := merchantLocks.Lock(name)
+	defer unlock()
+
+	merchant, err := u.GetMerchantWithName(name)
+	if err != nil {
+		return err
+	}
+
+	payable, err := u.ledgerSrv.GetBalance(model.MerchantPayable(merchant.Name, merchant.Currency))
+	if err != nil {
+		return err
+	}
+
+	owed, err := payable.Net()
+	if err != nil {
+		return err
+	}
+
+	if owed != 0 {
+		return fmt.Errorf("merchant has an outstanding payable balance")
+	}
+
+	auths, err := u.dbSrv.Query(model.Authorization{}, model.NewQuery().Where("MerchantName", model.Eq, merchant.Name).Where("State", model.Eq, model.AUTHORIZATION_PENDING))
+	if err != nil {
+		return err
+	}
+
+	now := u.dbSrv.Clock().Now()
+
+	for _, m := range auths {
+		auth, ok := m.(model.Authorization)
+		if !ok {
+			return fmt.Errorf("can not able to type assert authorization")
+		}
+
+		if auth.Holds(now) {
+			return fmt.Errorf("merchant has pending authorizations")
+		}
+	}
+
+	if err := u.dbSrv.Delete(*merchant); err != nil {
+		u.l.ErrorD("can not able to delete merchant", log.Fields{"merchant": merchant.Name})
+		return err
+	}
+
+	return nil
 }
@@ -2,18 +2,25 @@ package report
 
 import (
 	"fmt"
+	"pay-later/integration/locale"
 	"pay-later/integration/log"
 	"pay-later/model"
+	"pay-later/service/ledger"
 	"pay-later/service/merchant"
 	"pay-later/service/transaction"
 	"pay-later/service/user"
+	"sort"
+	"strings"
+	"text/tabwriter"
 )
 
 type ReportService interface {
 	GetTotalDiscount(string) (string, error)
 	GetTotalDuesForUser(string) (string, error)
+	GetAvailableCredit(string) (string, error)
 	GetUsersAtCreditLimit() ([]string, error)
 	TotalDues() (string, error)
+	TrialBalance() (string, error)
 }
 
 type reportService struct {
@@ -22,36 +29,94 @@ type reportService struct {
 	dbSrv       model.ModelManager
 	usrSrv      user.UserService
 	merchantSrv merchant.MerchantService
+	loc         locale.Locale
 }
 
-func NewReportingService(l log.Logger, txnSrv transaction.TransactionService, usrSrv user.UserService, merchantSrv merchant.MerchantService, dbSrv model.ModelManager) ReportService {
+// NewReportingService returns reports with amounts written for loc
+func NewReportingService(l log.Logger, txnSrv transaction.TransactionService, usrSrv user.UserService, merchantSrv merchant.MerchantService, dbSrv model.ModelManager, loc locale.Locale) ReportService {
 	return &reportService{
-		l, txnSrv, dbSrv, usrSrv, merchantSrv,
+		l, txnSrv, dbSrv, usrSrv, merchantSrv, loc,
 	}
 }
 
 func (r reportService) GetTotalDiscount(merchant string) (string, error) {
-	dues, err := r.txnService.GetTotalDiscountForMerchant(merchant)
+	totals, err := r.txnService.GetMerchantTotals(merchant)
 	if err != nil {
 		return "", err
 	}
-	if dues == nil {
+	if totals == nil {
 		return "", fmt.Errorf("can not able to find dues")
 	}
 
-	amountInDollars := float64(*dues) / float64(100)
-	return fmt.Sprintf("%0.2f", amountInDollars), nil
+	currency := totals.Currency.OrDefault()
+	return r.loc.Format(totals.DiscountEarned, currency), nil
 }
 
+// GetTotalDuesForUser is what the user owes, with the late fees ever charged
+// to them when there are any
 func (r reportService) GetTotalDuesForUser(name string) (string, error) {
 	usr, err := r.usrSrv.GetUserWithName(name)
 	if err != nil {
 		return "", err
 	}
 
-	duesInDollars := float64(usr.Dues) / float64(100)
+	dues, err := r.usrSrv.GetUserDues(usr.Name)
+	if err != nil {
+		return "", err
+	}
+
+	fees, err := r.dbSrv.Query(model.LateFeeTransfer{}, model.NewQuery().Where("UserName", model.Eq, usr.Name))
+	if err != nil {
+		return "", err
+	}
 
-	return fmt.Sprintf("%0.2f", duesInDollars), nil
+	var lateFees model.Money
+	for _, m := range fees {
+		fee, ok := m.(model.LateFeeTransfer)
+		if !ok {
+			return "", fmt.Errorf("can not able to type assert late fee")
+		}
+
+		if lateFees, err = lateFees.Add(fee.Amount); err != nil {
+			return "", err
+		}
+	}
+
+	if lateFees == 0 {
+		return r.formatDues(dues, usr.Currency), nil
+	}
+
+	return fmt.Sprintf("%s (late fees charged %s)", r.formatDues(dues, usr.Currency), r.loc.Format(lateFees, usr.Currency)), nil
+}
+
+// GetAvailableCredit is what the user can still spend, with what pending
+// authorizations hold when there are any
+func (r reportService) GetAvailableCredit(name string) (string, error) {
+	usr, err := r.usrSrv.GetUserWithName(name)
+	if err != nil {
+		return "", err
+	}
+
+	available, held, err := r.usrSrv.GetAvailableCredit(usr.Name)
+	if err != nil {
+		return "", err
+	}
+
+	if held == 0 {
+		return r.loc.Format(available, usr.Currency), nil
+	}
+
+	return fmt.Sprintf("%s (%s held)", r.loc.Format(available, usr.Currency), r.loc.Format(held, usr.Currency)), nil
+}
+
+// formatDues writes dues, or the credit balance for negative dues
+func (r reportService) formatDues(dues model.Money, currency model.Currency) string {
+
+	if dues.IsNegative() {
+		return r.loc.Format(-dues, currency) + " in credit"
+	}
+
+	return r.loc.Format(dues, currency)
 }
 
 func (r reportService) GetUsersAtCreditLimit() ([]string, error) {
@@ -77,18 +142,120 @@ func (r reportService) TotalDues() (string, error) {
 		return "", err
 	}
 
+	//dues in different currencies can not be added up, so total each one.
+	//credit balances are totalled apart rather than netted against the dues
 	var resp = ""
-	var total float64 = 0
+	var totals = make(map[model.Currency]model.Money)
+	var credits = make(map[model.Currency]model.Money)
 	for _, usr := range users {
-		if usr != nil {
 
-			duesInDollars := float64(usr.Dues) / float64(100)
-			total += duesInDollars
-			resp += fmt.Sprintf("%s: %0.2f\n", usr.Name, duesInDollars)
+		currency := usr.User.Currency.OrDefault()
+		dues, credit := usr.Dues, model.Money(0)
+		if dues.IsNegative() {
+			dues, credit = 0, -dues
 		}
 
+		if totals[currency], err = totals[currency].Add(dues); err != nil {
+			return "", err
+		}
+		if credits[currency], err = credits[currency].Add(credit); err != nil {
+			return "", err
+		}
+		resp += fmt.Sprintf("%s: %s\n", usr.User.Name, r.formatDues(usr.Dues, currency))
+
+	}
+
+	if len(totals) == 0 {
+		totals[model.DefaultCurrency] = 0
+	}
+
+	var currencies = make([]string, 0, len(totals))
+	for currency := range totals {
+		currencies = append(currencies, string(currency))
+	}
+	sort.Strings(currencies)
+
+	var lines = make([]string, 0, len(currencies))
+	for _, currency := range currencies {
+		c := model.Currency(currency)
+		line := fmt.Sprintf("total %s: %s", c, r.loc.Format(totals[c], c))
+		if credits[c] != 0 {
+			line += fmt.Sprintf(", credit %s", r.loc.Format(credits[c], c))
+		}
+		lines = append(lines, line)
+	}
+
+	resp += strings.Join(lines, "\n")
+	return resp, nil
+}
+
+// TrialBalance lists the debits, credits and net balance of every account
+// with a posting, and the totals per currency. every entry balances, so each
+// currency has to net to zero, and an error is returned along with the
+// listing when one does not.
+func (r reportService) TrialBalance() (string, error) {
+	balances, err := ledger.NewLedgerService(r.dbSrv, r.l).GetBalances()
+	if err != nil {
+		return "", err
+	}
+
+	var totals = make(map[model.Currency]*model.Balance)
+	var currencies []string
+
+	var buf strings.Builder
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "account\tdebit\tcredit\tnet")
+
+	for _, balance := range balances {
+		currency := balance.Account.Currency
+
+		net, err := balance.Net()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", balance.Account.ID(),
+			r.loc.Format(balance.Debit, currency), r.loc.Format(balance.Credit, currency), r.loc.Format(net, currency))
+
+		total, ok := totals[currency]
+		if !ok {
+			total = &model.Balance{Account: model.Account{Currency: currency}}
+			totals[currency] = total
+			currencies = append(currencies, string(currency))
+		}
+
+		if err := total.Post(model.JournalLine{Debit: balance.Debit, Credit: balance.Credit}); err != nil {
+			return "", err
+		}
+	}
+
+	sort.Strings(currencies)
+
+	var unbalanced []string
+	for _, currency := range currencies {
+		c := model.Currency(currency)
+		total := totals[c]
+
+		net, err := total.Net()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(w, "total %s\t%s\t%s\t%s\n", c,
+			r.loc.Format(total.Debit, c), r.loc.Format(total.Credit, c), r.loc.Format(net, c))
+
+		if net != 0 {
+			unbalanced = append(unbalanced, fmt.Sprintf("%s by %s", c, r.loc.Format(net, c)))
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+
+	resp := strings.TrimRight(buf.String(), "\n")
+
+	if len(unbalanced) > 0 {
+		return resp, fmt.Errorf("trial balance is off in %s", strings.Join(unbalanced, ", "))
 	}
 
-	resp += fmt.Sprintf("total: %0.2f", total)
 	return resp, nil
 }
@@ -0,0 +1,179 @@
+package scheduler
+
+import (
+	"fmt"
+	"pay-later/integration/log"
+	"pay-later/model"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Job is a time based task, such as closing statements or charging late
+// fees. it runs once for every day the clock enters, and has to be
+// idempotent for a day since a day can be replayed after a failure.
+type Job interface {
+	Name() string
+	Run(day time.Time) error
+}
+
+// JobRun is the outcome of one job on one day
+type JobRun struct {
+	Job string
+	Day time.Time
+	Err error
+}
+
+type SchedulerService interface {
+	Now() time.Time
+	Set(time.Time) ([]JobRun, error)
+	Advance(time.Duration) ([]JobRun, error)
+}
+
+// simulatedClockName is the row the simulated clock is kept in
+const simulatedClockName = "simulated"
+
+type schedulerService struct {
+	l     log.Logger
+	clock *model.ManualClock
+	dbSrv model.ModelManager
+	jobs  []Job
+}
+
+// NewSchedulerService moves clock, keeping where it was moved to in dbSrv
+func NewSchedulerService(l log.Logger, clock *model.ManualClock, dbSrv model.ModelManager, jobs ...Job) SchedulerService {
+	return &schedulerService{
+		l, clock, dbSrv, jobs,
+	}
+}
+
+// RestoreClock moves clock to where it was last moved to in dbSrv, leaving
+// it as it is for a book it was never moved in
+func RestoreClock(clock *model.ManualClock, dbSrv model.ModelManager) error {
+
+	saved, found, err := dbSrv.GetWithPrimaryKey(model.ClockTime{Name: simulatedClockName})
+	if err != nil || !found {
+		return err
+	}
+
+	ct, ok := saved.(model.ClockTime)
+	if !ok {
+		return fmt.Errorf("can not able to type assert clock time")
+	}
+
+	clock.Set(ct.Now)
+
+	return nil
+}
+
+func (s schedulerService) Now() time.Time {
+	return s.clock.Now()
+}
+
+// Set moves the clock forward to t, running the jobs of every day entered
+// on the way. it never moves backwards, which would post into cycles whose
+// statements are already closed.
+func (s schedulerService) Set(t time.Time) ([]JobRun, error) {
+
+	if t.Before(s.clock.Now()) {
+		return nil, fmt.Errorf("can not move the clock backwards from %s", s.clock.Now().Format(time.RFC3339))
+	}
+
+	var runs = make([]JobRun, 0)
+
+	for day := nextDay(s.clock.Now()); !day.After(t); day = nextDay(day) {
+
+		s.clock.Set(day) //whatever the jobs post is stamped with the day they ran for
+
+		for _, job := range s.jobs {
+			err := job.Run(day)
+			runs = append(runs, JobRun{job.Name(), day, err})
+
+			if err != nil {
+				s.l.ErrorD("scheduled job failed", log.Fields{"job": job.Name(), "day": day, "error": err.Error()})
+				if serr := s.save(); serr != nil {
+					return runs, serr
+				}
+				return runs, fmt.Errorf("%s failed on %s, clock stopped there: %v", job.Name(), day.Format("2006-01-02"), err)
+			}
+		}
+	}
+
+	s.clock.Set(t)
+
+	return runs, s.save()
+}
+
+// save keeps where the clock is, so that a restart carries on from there
+func (s schedulerService) save() error {
+
+	ct := model.ClockTime{Name: simulatedClockName}
+
+	saved, found, err := s.dbSrv.GetWithPrimaryKey(ct)
+	if err != nil {
+		return err
+	}
+
+	if found {
+		var ok bool
+		if ct, ok = saved.(model.ClockTime); !ok {
+			return fmt.Errorf("can not able to type assert clock time")
+		}
+	}
+
+	ct.Now = s.clock.Now()
+
+	_, err = s.dbSrv.Upsert(ct)
+	return err
+}
+
+func (s schedulerService) Advance(d time.Duration) ([]JobRun, error) {
+
+	if d < 0 {
+		return nil, fmt.Errorf("can not advance the clock backwards")
+	}
+
+	return s.Set(s.clock.Now().Add(d))
+}
+
+// nextDay is the start of the day after t, in the location of t
+func nextDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, t.Location())
+}
+
+// ParseDuration extends time.ParseDuration with a leading day count, as in
+// "30d" or "1d12h"
+func ParseDuration(str string) (time.Duration, error) {
+
+	i := strings.Index(str, "d")
+	if i < 0 {
+		return time.ParseDuration(str)
+	}
+
+	days, err := strconv.Atoi(str[:i])
+	if err != nil || days < 0 {
+		return 0, fmt.Errorf("invalid duration %s", str)
+	}
+
+	var rest time.Duration
+	if str[i+1:] != "" {
+		if rest, err = time.ParseDuration(str[i+1:]); err != nil {
+			return 0, err
+		}
+	}
+
+	return time.Duration(days)*24*time.Hour + rest, nil
+}
+
+// ParseTime reads a date, optionally with a time of day, in UTC
+func ParseTime(str string) (time.Time, error) {
+
+	for _, layout := range []string{"2006-01-02", "2006-01-02T15:04", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, str, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time %s, expected YYYY-MM-DD", str)
+}
@@ -4,11 +4,26 @@ import (
 	"fmt"
 	"pay-later/integration/log"
 	"pay-later/model"
+	"sort"
 )
 
 type TransactionService interface {
 	CreateTransaction(*model.Transaction) (*model.Transaction, error)
-	GetTotalDiscountForMerchant(string) (*int, error)
+	GetTotalDiscountForMerchant(string) (*model.Money, error)
+	GetMerchantTotals(string) (*model.MerchantTotals, error)
+	RebuildMerchantTotals() ([]MerchantTotalsCheck, error)
+	WithModelManager(model.ModelManager) TransactionService
+}
+
+// MerchantTotalsCheck pairs the running totals of a merchant with the ones
+// recomputed from the ledger
+type MerchantTotalsCheck struct {
+	Stored model.MerchantTotals
+	Ledger model.MerchantTotals
+}
+
+func (c MerchantTotalsCheck) Matches() bool {
+	return c.Stored.SameTotals(c.Ledger)
 }
 
 type transactionService struct {
@@ -22,6 +37,16 @@ func NewTransactionService(db model.ModelManager, log log.Logger) TransactionSer
 	}
 }
 
+// WithModelManager returns a copy of the service working on db, typically a
+// model.Tx so that its writes join a unit of work
+func (t transactionService) WithModelManager(db model.ModelManager) TransactionService {
+	t.db = db
+	return &t
+}
+
+// CreateTransaction writes the transaction and folds it into the running
+// totals of its merchant, in one unit of work. callers posting to the
+// totals of a merchant hold its MerchantService.LockMerchant.
 func (t transactionService) CreateTransaction(transaction *model.Transaction) (*model.Transaction, error) {
 
 	if transaction == nil {
@@ -29,7 +54,18 @@ func (t transactionService) CreateTransaction(transaction *model.Transaction) (*
 		return nil, fmt.Errorf("nil transaction object")
 	}
 
-	_, found, err := t.db.GetWithPrimaryKey(*transaction)
+	db := t.db
+	tx, inTx := db.(model.Tx)
+	if !inTx {
+		var err error
+		if tx, err = db.Begin(); err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+		db = tx
+	}
+
+	_, found, err := db.GetWithPrimaryKey(*transaction)
 	if err != nil {
 		t.l.ErrorD("error checking if the transaction already exist", log.Fields{"transaction": transaction})
 		return nil, err
@@ -39,35 +75,171 @@ func (t transactionService) CreateTransaction(transaction *model.Transaction) (*
 		return nil, fmt.Errorf("transaction already exist")
 	}
 
-	txn, err := t.db.Upsert(*transaction)
+	txn, err := db.Upsert(*transaction)
 	if err != nil {
 		return nil, err
 	}
 
 	nTxn := txn.(model.Transaction)
 
+	if err := t.addToTotals(db, nTxn); err != nil {
+		t.l.ErrorD("can not able to update merchant totals", log.Fields{"transaction": nTxn})
+		return nil, err
+	}
+
+	if !inTx {
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+	}
+
 	return &nTxn, nil
 }
 
-func (t transactionService) GetTotalDiscountForMerchant(merchantName string) (*int, error) {
+func (t transactionService) addToTotals(db model.ModelManager, txn model.Transaction) error {
+
+	merchantName := model.TotalsMerchantName(txn)
+	if merchantName == "" {
+		return nil
+	}
+
+	totals, err := getMerchantTotals(db, merchantName)
+	if err != nil {
+		return err
+	}
+
+	if err := totals.Apply(txn); err != nil {
+		return err
+	}
+
+	_, err = db.Upsert(*totals)
+	return err
+}
+
+func getMerchantTotals(db model.ModelManager, merchantName string) (*model.MerchantTotals, error) {
+
+	totals := model.MerchantTotals{
+		MerchantName: merchantName,
+	}
+
+	tModel, found, err := db.GetWithPrimaryKey(totals)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return &totals, nil
+	}
+
+	totals, ok := tModel.(model.MerchantTotals)
+	if !ok {
+		return nil, fmt.Errorf("can not able to type assert merchant totals")
+	}
+
+	return &totals, nil
+}
+
+func (t transactionService) GetMerchantTotals(merchantName string) (*model.MerchantTotals, error) {
+	return getMerchantTotals(t.db, merchantName)
+}
+
+func (t transactionService) GetTotalDiscountForMerchant(merchantName string) (*model.Money, error) {
 
-	var totalDiscount int = 0
+	totals, err := getMerchantTotals(t.db, merchantName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &totals.DiscountEarned, nil
+}
+
+// RebuildMerchantTotals recomputes the totals of every merchant from the
+// ledger, repairs the ones that drifted and returns every comparison.
+// callers make sure no transfer is posted meanwhile.
+func (t transactionService) RebuildMerchantTotals() ([]MerchantTotalsCheck, error) {
 
 	transactions, err := t.db.GetAll(model.Transaction{})
 	if err != nil {
 		return nil, err
 	}
 
+	var ledger = make(map[string]*model.MerchantTotals)
+
 	for _, txn := range transactions {
 		nTxn, ok := txn.(model.Transaction)
 		if !ok {
 			return nil, fmt.Errorf("can not able to type assert transaction")
 		}
 
-		if nTxn.Type == model.MERCHANT_DISCOUNT_CREDIT && nTxn.SourceName == merchantName && nTxn.DestinationName == model.CLEARING_ACCOUNT_NAME {
-			totalDiscount += nTxn.Amount
+		merchantName := model.TotalsMerchantName(nTxn)
+		if merchantName == "" {
+			continue
+		}
+
+		totals, ok := ledger[merchantName]
+		if !ok {
+			totals = &model.MerchantTotals{MerchantName: merchantName}
+			ledger[merchantName] = totals
+		}
+
+		if err := totals.Apply(nTxn); err != nil {
+			return nil, err
+		}
+	}
+
+	stored, err := t.db.Query(model.MerchantTotals{}, model.NewQuery())
+	if err != nil {
+		return nil, err
+	}
+
+	var resp = make([]MerchantTotalsCheck, 0, len(stored))
+
+	for _, row := range stored {
+		totals, ok := row.(model.MerchantTotals)
+		if !ok {
+			return nil, fmt.Errorf("can not able to type assert merchant totals")
+		}
+
+		check := MerchantTotalsCheck{Stored: totals, Ledger: model.MerchantTotals{MerchantName: totals.MerchantName}}
+		if l, ok := ledger[totals.MerchantName]; ok {
+			check.Ledger = *l
+			delete(ledger, totals.MerchantName)
+		}
+
+		resp = append(resp, check)
+	}
+
+	for name, l := range ledger { //merchants with transactions but no totals row at all
+		resp = append(resp, MerchantTotalsCheck{Stored: model.MerchantTotals{MerchantName: name}, Ledger: *l})
+	}
+
+	sort.Slice(resp, func(i, j int) bool {
+		return resp[i].Stored.MerchantName < resp[j].Stored.MerchantName
+	})
+
+	tx, err := t.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for _, check := range resp {
+		if check.Matches() {
+			continue
+		}
+
+		t.l.WarnD("repairing merchant totals", log.Fields{"stored": check.Stored, "ledger": check.Ledger})
+
+		repaired := check.Ledger
+		repaired.Version = check.Stored.Version
+		if _, err := tx.Upsert(repaired); err != nil {
+			return nil, err
 		}
 	}
 
-	return &totalDiscount, nil
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
 }
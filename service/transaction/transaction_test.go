@@ -0,0 +1,114 @@
+package transaction
+
+import (
+	"fmt"
+	"io/ioutil"
+	"pay-later/integration/log"
+	"pay-later/model"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func newTestService(tb testing.TB) TransactionService {
+	tb.Helper()
+
+	l := log.NewLogger(log.SetOutput(ioutil.Discard))
+
+	db, err := model.NewModelManager(l)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return NewTransactionService(db, l)
+}
+
+// createTransaction writes a transaction of typ between the user u1 and
+// merchant, in the direction each type is written by the transfers
+func createTransaction(tb testing.TB, srv TransactionService, typ model.TransactionType, merchant string, amount model.Money) {
+	tb.Helper()
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	txn := model.Transaction{ID: id, TransferID: id, Type: typ, Amount: amount, Currency: model.DefaultCurrency}
+
+	switch typ {
+	case model.USER_MERCHANT_TRANSFER:
+		txn.SourceName, txn.DestinationName = "u1", merchant
+	case model.MERCHANT_DISCOUNT_CREDIT:
+		txn.SourceName, txn.DestinationName = merchant, model.CLEARING_ACCOUNT_NAME
+	case model.MERCHANT_REFUND:
+		txn.SourceName, txn.DestinationName = merchant, "u1"
+	case model.DISCOUNT_CLAWBACK:
+		txn.SourceName, txn.DestinationName = model.CLEARING_ACCOUNT_NAME, merchant
+	}
+
+	if _, err := srv.CreateTransaction(&txn); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func TestRebuildMerchantTotalsMatchesAfterPurchasesAndRefunds(t *testing.T) {
+
+	srv := newTestService(t)
+
+	for i := 0; i < 10; i++ {
+		merchant := fmt.Sprintf("m%d", i%2+1)
+
+		createTransaction(t, srv, model.USER_MERCHANT_TRANSFER, merchant, 9850)
+		createTransaction(t, srv, model.MERCHANT_DISCOUNT_CREDIT, merchant, 150)
+
+		if i%3 == 0 {
+			createTransaction(t, srv, model.MERCHANT_REFUND, merchant, 4925)
+			createTransaction(t, srv, model.DISCOUNT_CLAWBACK, merchant, 75)
+		}
+	}
+
+	checks, err := srv.RebuildMerchantTotals()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(checks) != 2 {
+		t.Fatalf("got %d merchants, want 2", len(checks))
+	}
+
+	//m1 had purchases 0, 2, 4, 6, 8 and refunds of 0 and 6, m2 purchases 1, 3, 5, 7, 9 and refunds of 3 and 9
+	want := model.MerchantTotals{DiscountEarned: 5*150 - 2*75, NetPaid: 5*9850 - 2*4925, TransferCount: 5, Currency: model.DefaultCurrency}
+	want.GrossVolume = want.DiscountEarned + want.NetPaid
+
+	for _, check := range checks {
+		if !check.Matches() {
+			t.Errorf("%s: stored %+v, ledger %+v", check.Stored.MerchantName, check.Stored, check.Ledger)
+		}
+
+		want.MerchantName = check.Stored.MerchantName
+		if !check.Ledger.SameTotals(want) {
+			t.Errorf("%s: got %+v, want %+v", check.Stored.MerchantName, check.Ledger, want)
+		}
+	}
+}
+
+func BenchmarkGetTotalDiscountForMerchant(b *testing.B) {
+
+	for _, n := range []int{1000, 10000, 100000} {
+		b.Run(fmt.Sprintf("transactions=%d", n), func(b *testing.B) {
+
+			srv := newTestService(b)
+			for i := 0; i < n; i++ {
+				createTransaction(b, srv, model.MERCHANT_DISCOUNT_CREDIT, fmt.Sprintf("m%d", i%10), 150)
+			}
+
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := srv.GetTotalDiscountForMerchant("m1"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
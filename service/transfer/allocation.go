@@ -0,0 +1,304 @@
+package transfer
+
+import (
+	"fmt"
+	"pay-later/model"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OpenCharge is a late fee, interest or inter transfer charged to a user
+// that is not paid back in full yet
+type OpenCharge struct {
+	Bucket   model.AllocationBucket
+	ChargeID uuid.UUID
+	PostedAt time.Time
+	Amount   model.Money //charged, less what was refunded of it
+	Open     model.Money //left to pay
+	Currency model.Currency
+}
+
+// bucketOrder is the order paybacks are allocated in
+var bucketOrder = map[model.AllocationBucket]int{
+	model.FEE_ALLOCATION:       0,
+	model.INTEREST_ALLOCATION:  1,
+	model.PRINCIPAL_ALLOCATION: 2,
+	model.UNAPPLIED_ALLOCATION: 3,
+}
+
+// openCharges are the charges of the user left to pay, in the order paybacks
+// are allocated to them, and the credit left once they are. the credit is
+// what was paid back unapplied or paid of a charge refunded since, less the
+// credit refunds, and it pays the charges recorded allocations leave open in
+// that same order, as do paybacks made before allocations were recorded.
+func openCharges(db model.ModelManager, userName string) ([]OpenCharge, model.Money, error) {
+
+	byUser := model.NewQuery().Where("UserName", model.Eq, userName)
+
+	var charges []OpenCharge
+
+	fees, err := db.Query(model.LateFeeTransfer{}, byUser)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, m := range fees {
+		t, ok := m.(model.LateFeeTransfer)
+		if !ok {
+			return nil, 0, fmt.Errorf("can not able to type assert transfer")
+		}
+		charges = append(charges, OpenCharge{model.FEE_ALLOCATION, t.ID, t.PostedAt, t.Amount, t.Amount, t.Currency})
+	}
+
+	interest, err := db.Query(model.InterestTransfer{}, byUser)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, m := range interest {
+		t, ok := m.(model.InterestTransfer)
+		if !ok {
+			return nil, 0, fmt.Errorf("can not able to type assert transfer")
+		}
+		charges = append(charges, OpenCharge{model.INTEREST_ALLOCATION, t.ID, t.PostedAt, t.Amount, t.Amount, t.Currency})
+	}
+
+	transfers, err := db.Query(model.InterTransfer{}, byUser)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, m := range transfers {
+		t, ok := m.(model.InterTransfer)
+		if !ok {
+			return nil, 0, fmt.Errorf("can not able to type assert transfer")
+		}
+		charges = append(charges, OpenCharge{model.PRINCIPAL_ALLOCATION, t.ID, t.PostedAt, t.Amount, t.Amount, t.Currency})
+	}
+
+	//a refund takes its part off the charge of the transfer it reverses
+	refunded := make(map[uuid.UUID]model.Money)
+
+	refundTransfers, err := db.Query(model.RefundTransfer{}, byUser)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, m := range refundTransfers {
+		t, ok := m.(model.RefundTransfer)
+		if !ok {
+			return nil, 0, fmt.Errorf("can not able to type assert transfer")
+		}
+
+		if refunded[t.TransferID], err = refunded[t.TransferID].Add(t.Amount); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	for i := range charges {
+		if charges[i].Amount, err = charges[i].Amount.Sub(refunded[charges[i].ChargeID]); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	sort.SliceStable(charges, func(i, j int) bool {
+		if charges[i].Bucket != charges[j].Bucket {
+			return bucketOrder[charges[i].Bucket] < bucketOrder[charges[j].Bucket]
+		}
+		return charges[i].PostedAt.Before(charges[j].PostedAt)
+	})
+
+	allocations, err := db.Query(model.PaybackAllocation{}, byUser)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var credit model.Money
+	var allocated = make(map[uuid.UUID]model.Money)
+	var allocatedPaybacks = make(map[uuid.UUID]bool)
+	for _, m := range allocations {
+		a, ok := m.(model.PaybackAllocation)
+		if !ok {
+			return nil, 0, fmt.Errorf("can not able to type assert allocation")
+		}
+
+		if a.Bucket == model.UNAPPLIED_ALLOCATION {
+			credit, err = credit.Add(a.Amount)
+		} else {
+			allocated[a.ChargeID], err = allocated[a.ChargeID].Add(a.Amount)
+		}
+
+		if err != nil {
+			return nil, 0, err
+		}
+		allocatedPaybacks[a.TransferID] = true
+	}
+
+	paybacks, err := db.Query(model.UserPaybackTransfer{}, byUser)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, m := range paybacks {
+		t, ok := m.(model.UserPaybackTransfer)
+		if !ok {
+			return nil, 0, fmt.Errorf("can not able to type assert transfer")
+		}
+
+		if !allocatedPaybacks[t.ID] {
+			if credit, err = credit.Add(t.Amount); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+
+	refunds, err := db.Query(model.CreditRefundTransfer{}, byUser)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, m := range refunds {
+		t, ok := m.(model.CreditRefundTransfer)
+		if !ok {
+			return nil, 0, fmt.Errorf("can not able to type assert transfer")
+		}
+
+		if credit, err = credit.Sub(t.Amount); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	//what was paid of a charge that was refunded afterwards is a credit too
+	for i := range charges {
+		if charges[i].Open, err = charges[i].Amount.Sub(allocated[charges[i].ChargeID]); err != nil {
+			return nil, 0, err
+		}
+
+		if charges[i].Open.IsNegative() {
+			if credit, err = credit.Sub(charges[i].Open); err != nil {
+				return nil, 0, err
+			}
+			charges[i].Open = 0
+		}
+	}
+
+	var resp = make([]OpenCharge, 0, len(charges))
+	for _, c := range charges {
+		paid := minMoney(c.Open, credit)
+		if paid > 0 {
+			c.Open -= paid
+			credit -= paid
+		}
+
+		if c.Open > 0 {
+			resp = append(resp, c)
+		}
+	}
+
+	return resp, credit, nil
+}
+
+// allocate splits the payback over the open charges of its user, writing an
+// allocation row for each charge it pays and one for what is left unapplied
+func allocate(db model.ModelManager, payback model.UserPaybackTransfer) ([]model.PaybackAllocation, error) {
+
+	charges, _, err := openCharges(db, payback.UserName)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp []model.PaybackAllocation
+
+	remaining := payback.Amount
+	write := func(bucket model.AllocationBucket, chargeID uuid.UUID, amount model.Money) error {
+
+		id, err := uuid.NewUUID()
+		if err != nil {
+			return fmt.Errorf("can not able to generate allocation id")
+		}
+
+		aModel, err := db.Upsert(model.PaybackAllocation{
+			ID:         id,
+			TransferID: payback.ID,
+			UserName:   payback.UserName,
+			Bucket:     bucket,
+			ChargeID:   chargeID,
+			Amount:     amount,
+			Currency:   payback.Currency,
+		})
+		if err != nil {
+			return err
+		}
+
+		resp = append(resp, aModel.(model.PaybackAllocation))
+		remaining -= amount
+
+		return nil
+	}
+
+	for _, c := range charges {
+		if remaining == 0 {
+			break
+		}
+
+		if err := write(c.Bucket, c.ChargeID, minMoney(c.Open, remaining)); err != nil {
+			return nil, err
+		}
+	}
+
+	if remaining > 0 {
+		if err := write(model.UNAPPLIED_ALLOCATION, uuid.Nil, remaining); err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+func minMoney(a model.Money, b model.Money) model.Money {
+
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+// GetOpenCharges are the charges of the user left to pay, in the order
+// paybacks are allocated to them
+func (t transferService) GetOpenCharges(userName string) ([]OpenCharge, error) {
+
+	user, err := t.usrSrv.GetUserWithName(userName)
+	if err != nil {
+		return nil, err
+	}
+
+	charges, _, err := openCharges(t.dbSrv, user.Name)
+	return charges, err
+}
+
+// GetAllocations are how the payback was allocated
+func (t transferService) GetAllocations(transferID uuid.UUID) ([]model.PaybackAllocation, error) {
+
+	models, err := t.dbSrv.Query(model.PaybackAllocation{}, model.NewQuery().Where("TransferID", model.Eq, transferID))
+	if err != nil {
+		return nil, err
+	}
+
+	var resp = make([]model.PaybackAllocation, 0, len(models))
+	for _, m := range models {
+		a, ok := m.(model.PaybackAllocation)
+		if !ok {
+			return nil, fmt.Errorf("can not able to type assert allocation")
+		}
+		resp = append(resp, a)
+	}
+
+	sort.SliceStable(resp, func(i, j int) bool {
+		return bucketOrder[resp[i].Bucket] < bucketOrder[resp[j].Bucket]
+	})
+
+	return resp, nil
+}
@@ -0,0 +1,382 @@
+package transfer
+
+import (
+	"fmt"
+	"pay-later/integration/log"
+	"pay-later/model"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	defaultHoldDurationMu sync.RWMutex
+	defaultHoldDuration   = 7 * 24 * time.Hour
+)
+
+// DefaultHoldDuration is how long an authorization reserves credit before it
+// expires, 7 days unless changed with SetDefaultHoldDuration
+func DefaultHoldDuration() time.Duration {
+
+	defaultHoldDurationMu.RLock()
+	defer defaultHoldDurationMu.RUnlock()
+
+	return defaultHoldDuration
+}
+
+func SetDefaultHoldDuration(d time.Duration) error {
+
+	if d <= 0 {
+		return fmt.Errorf("invalid hold duration %s", d)
+	}
+
+	defaultHoldDurationMu.Lock()
+	defaultHoldDuration = d
+	defaultHoldDurationMu.Unlock()
+
+	return nil
+}
+
+// Authorize holds amount, in the merchant's currency, of the user's credit
+// for a sale whose final amount is captured later. nothing is owed until the
+// capture, but the hold counts against the credit limit until then.
+func (t transferService) Authorize(userName string, merchantName string, amount model.Money, opts ...TransferOption) (*model.Authorization, error) {
+
+	var o transferOpts
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if amount <= 0 {
+		return nil, fmt.Errorf("amount should be greater than zero")
+	}
+
+	unlock := t.usrSrv.LockUser(userName)
+	defer unlock()
+
+	unlockMerchant := t.merchantSrv.LockMerchant(merchantName)
+	defer unlockMerchant()
+
+	tx, err := t.dbSrv.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	usrSrv := t.usrSrv.WithModelManager(tx)
+	ledgerSrv := t.ledgerSrv.WithModelManager(tx)
+
+	user, err := usrSrv.GetUserWithName(userName)
+	if err != nil || user == nil {
+		return nil, err
+	}
+
+	merchant, err := t.merchantSrv.GetMerchantWithName(merchantName)
+	if err != nil || merchant == nil {
+		return nil, err
+	}
+
+	held, _, err := t.charge(*user, *merchant, amount, o)
+	if err != nil {
+		return nil, err
+	}
+
+	dues, err := ledgerSrv.GetUserDues(user.Name, user.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	pending, err := usrSrv.GetHeldAmount(user.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if dues, err = dues.Add(pending); err != nil {
+		return nil, err
+	}
+
+	if !user.AllowAmount(dues, held) {
+		return nil, fmt.Errorf("credit limit reached")
+	}
+
+	authID, err := uuid.NewUUID()
+	if err != nil {
+		return nil, fmt.Errorf("can not able to generate authorization id")
+	}
+
+	aModel, err := tx.Upsert(model.Authorization{
+		ID:               authID,
+		UserName:         user.Name,
+		MerchantName:     merchant.Name,
+		Amount:           held,
+		Currency:         user.Currency.OrDefault(),
+		MerchantAmount:   amount,
+		MerchantCurrency: merchant.Currency.OrDefault(),
+		Convert:          o.convert,
+		State:            model.AUTHORIZATION_PENDING,
+		ExpiresAt:        tx.Clock().Now().Add(DefaultHoldDuration()),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	auth, ok := aModel.(model.Authorization)
+	if !ok {
+		return nil, fmt.Errorf("can not able to type assert authorization")
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.l.Error("error committing authorization", log.Fields{"authorization": auth})
+		return nil, err
+	}
+
+	return &auth, nil
+}
+
+// CaptureAuthorization turns a pending authorization into an inter transfer
+// of amount, all that was authorized for a zero amount. the rest of the hold
+// is released, an authorization is captured once.
+func (t transferService) CaptureAuthorization(authID uuid.UUID, amount model.Money) (*model.InterTransfer, error) {
+
+	if amount.IsNegative() {
+		return nil, fmt.Errorf("invalid amount")
+	}
+
+	auth, err := t.GetAuthorization(authID)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := t.usrSrv.LockUser(auth.UserName)
+	defer unlock()
+
+	unlockMerchant := t.merchantSrv.LockMerchant(auth.MerchantName)
+	defer unlockMerchant()
+
+	tx, err := t.dbSrv.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	current, err := pendingAuthorization(tx, authID)
+	if err != nil {
+		return nil, err
+	}
+
+	if amount == 0 {
+		amount = current.MerchantAmount
+	}
+
+	if amount > current.MerchantAmount {
+		return nil, fmt.Errorf("capture amount should be less than or equal to the authorized amount")
+	}
+
+	//released first, so that the transfer is checked against the other holds only
+	current.State = model.AUTHORIZATION_CAPTURED
+	current.CapturedAmount = amount
+	current.ClosedAt = tx.Clock().Now()
+
+	if _, err := tx.Upsert(*current); err != nil {
+		return nil, err
+	}
+
+	transfer, err := t.interTransfer(tx, current.UserName, current.MerchantName, amount, transferOpts{convert: current.Convert}, current.Amount)
+	if err != nil || transfer == nil {
+		return nil, err
+	}
+
+	current.TransferID = transfer.ID
+
+	if _, err := tx.Upsert(*current); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.l.Error("error committing capture", log.Fields{"authorization": current, "transfer": transfer})
+		return nil, err
+	}
+
+	return transfer, nil
+}
+
+// VoidAuthorization releases a pending authorization without charging the user
+func (t transferService) VoidAuthorization(authID uuid.UUID) (*model.Authorization, error) {
+
+	auth, err := t.GetAuthorization(authID)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.closeAuthorization(*auth, model.AUTHORIZATION_VOIDED)
+}
+
+// ExpireAuthorizations marks the pending authorizations that expired by asOf
+// as expired, returning them. holds stop reserving credit when they expire,
+// this only closes them.
+func (t transferService) ExpireAuthorizations(asOf time.Time) ([]model.Authorization, error) {
+
+	models, err := t.dbSrv.Query(model.Authorization{}, model.NewQuery().Where("State", model.Eq, model.AUTHORIZATION_PENDING).OrderBy("ExpiresAt", false))
+	if err != nil {
+		return nil, err
+	}
+
+	var resp = make([]model.Authorization, 0)
+	for _, m := range models {
+		auth, ok := m.(model.Authorization)
+		if !ok {
+			return nil, fmt.Errorf("can not able to type assert authorization")
+		}
+
+		if auth.ExpiresAt.After(asOf) {
+			continue
+		}
+
+		expired, err := t.closeAuthorization(auth, model.AUTHORIZATION_EXPIRED)
+		if err != nil {
+			return resp, err
+		}
+
+		resp = append(resp, *expired)
+	}
+
+	return resp, nil
+}
+
+// closeAuthorization moves a pending authorization to state, which posts
+// nothing since a hold never reaches the ledger
+func (t transferService) closeAuthorization(auth model.Authorization, state model.AuthorizationState) (*model.Authorization, error) {
+
+	unlock := t.usrSrv.LockUser(auth.UserName)
+	defer unlock()
+
+	tx, err := t.dbSrv.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	aModel, found, err := tx.GetWithPrimaryKey(auth)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, fmt.Errorf("authorization not found")
+	}
+
+	current, ok := aModel.(model.Authorization)
+	if !ok {
+		return nil, fmt.Errorf("can not able to type assert authorization")
+	}
+
+	if current.State != model.AUTHORIZATION_PENDING {
+		return nil, fmt.Errorf("authorization already %s", current.State)
+	}
+
+	current.State = state
+	current.ClosedAt = tx.Clock().Now()
+	if state == model.AUTHORIZATION_EXPIRED {
+		current.ClosedAt = current.ExpiresAt
+	}
+
+	cModel, err := tx.Upsert(current)
+	if err != nil {
+		return nil, err
+	}
+
+	closed, ok := cModel.(model.Authorization)
+	if !ok {
+		return nil, fmt.Errorf("can not able to type assert authorization")
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.l.Error("error committing authorization", log.Fields{"authorization": closed})
+		return nil, err
+	}
+
+	return &closed, nil
+}
+
+// pendingAuthorization reads the authorization again under the locks, it
+// has to still hold credit to be captured
+func pendingAuthorization(db model.ModelManager, authID uuid.UUID) (*model.Authorization, error) {
+
+	aModel, found, err := db.GetWithPrimaryKey(model.Authorization{ID: authID})
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, fmt.Errorf("authorization not found")
+	}
+
+	auth, ok := aModel.(model.Authorization)
+	if !ok {
+		return nil, fmt.Errorf("can not able to type assert authorization")
+	}
+
+	if auth.State != model.AUTHORIZATION_PENDING {
+		return nil, fmt.Errorf("authorization already %s", auth.State)
+	}
+
+	if !auth.Holds(db.Clock().Now()) {
+		return nil, fmt.Errorf("authorization expired")
+	}
+
+	return &auth, nil
+}
+
+func (t transferService) GetAuthorization(authID uuid.UUID) (*model.Authorization, error) {
+
+	aModel, found, err := t.dbSrv.GetWithPrimaryKey(model.Authorization{ID: authID})
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, fmt.Errorf("authorization not found")
+	}
+
+	auth, ok := aModel.(model.Authorization)
+	if !ok {
+		return nil, fmt.Errorf("can not able to type assert authorization")
+	}
+
+	return &auth, nil
+}
+
+// GetAuthorizations are the authorizations of the user, oldest first
+func (t transferService) GetAuthorizations(userName string) ([]model.Authorization, error) {
+
+	models, err := t.dbSrv.Query(model.Authorization{}, model.NewQuery().Where("UserName", model.Eq, userName).OrderBy("CreatedAt", false))
+	if err != nil {
+		return nil, err
+	}
+
+	var resp = make([]model.Authorization, 0, len(models))
+	for _, m := range models {
+		a, ok := m.(model.Authorization)
+		if !ok {
+			return nil, fmt.Errorf("can not able to type assert authorization")
+		}
+		resp = append(resp, a)
+	}
+
+	return resp, nil
+}
+
+// ExpiryJob expires the authorizations whose hold ended by the day it runs for
+type ExpiryJob struct {
+	Transfers TransferService
+}
+
+func (j ExpiryJob) Name() string {
+	return "authorization expiry"
+}
+
+func (j ExpiryJob) Run(day time.Time) error {
+	_, err := j.Transfers.ExpireAuthorizations(day)
+	return err
+}
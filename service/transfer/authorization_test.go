@@ -0,0 +1,279 @@
+package transfer
+
+import (
+	"pay-later/model"
+	"testing"
+	"time"
+)
+
+// newAuthorizationServices sets up u1 with a limit of 100.00 and m1, on a
+// clock that only moves when told to
+func newAuthorizationServices(t *testing.T) (testServices, *model.ManualClock) {
+	t.Helper()
+
+	clock := model.NewManualClock(time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC))
+	s := newTestServices(t, model.SetClock(clock))
+
+	if _, err := s.users.CreateNewUser("u1", "u1@users.com", mustParseMoney(t, "100.00"), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.merchants.CreateNewMerchant("m1", "m1@merchants.com", 150, "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	return s, clock
+}
+
+// assertCredit checks the dues and the held amount of u1
+func assertCredit(t *testing.T, s testServices, dues string, held string) {
+	t.Helper()
+
+	gotDues, err := s.users.GetUserDues("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, gotHeld, err := s.users.GetAvailableCredit("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gotDues != mustParseMoney(t, dues) || gotHeld != mustParseMoney(t, held) {
+		t.Errorf("got dues %s and %s held, want %s and %s", gotDues, gotHeld, dues, held)
+	}
+}
+
+func assertAuthorizationState(t *testing.T, s testServices, auth *model.Authorization, state model.AuthorizationState) *model.Authorization {
+	t.Helper()
+
+	current, err := s.transfers.GetAuthorization(auth.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if current.State != state {
+		t.Errorf("authorization %s, want %s", current.State, state)
+	}
+
+	return current
+}
+
+func TestAuthorizationHoldsCredit(t *testing.T) {
+
+	s, _ := newAuthorizationServices(t)
+
+	auth, err := s.transfers.Authorize("u1", "m1", mustParseMoney(t, "80.00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertCredit(t, s, "0", "80.00")
+
+	available, _, err := s.users.GetAvailableCredit("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if available != mustParseMoney(t, "20.00") {
+		t.Errorf("got %s available, want 20.00", available)
+	}
+
+	if _, err := s.transfers.CreateInterTransfer("u1", "m1", mustParseMoney(t, "20.01")); err == nil {
+		t.Error("transfer over the credit left beside the hold accepted")
+	}
+
+	if _, err := s.transfers.Authorize("u1", "m1", mustParseMoney(t, "20.01")); err == nil {
+		t.Error("hold over the credit left beside the hold accepted")
+	}
+
+	if _, err := s.transfers.CreateInterTransfer("u1", "m1", mustParseMoney(t, "20.00")); err != nil {
+		t.Errorf("transfer of the credit left rejected: %v", err)
+	}
+
+	assertCredit(t, s, "20.00", "80.00")
+	assertAuthorizationState(t, s, auth, model.AUTHORIZATION_PENDING)
+}
+
+func TestPartialCaptureReleasesTheRestOfTheHold(t *testing.T) {
+
+	s, _ := newAuthorizationServices(t)
+
+	auth, err := s.transfers.Authorize("u1", "m1", mustParseMoney(t, "80.00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transfer, err := s.transfers.CaptureAuthorization(auth.ID, mustParseMoney(t, "50.00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if transfer.Amount != mustParseMoney(t, "50.00") || transfer.MerchantName != "m1" {
+		t.Errorf("captured %s at %s, want 50.00 at m1", transfer.Amount, transfer.MerchantName)
+	}
+
+	assertCredit(t, s, "50.00", "0")
+
+	captured := assertAuthorizationState(t, s, auth, model.AUTHORIZATION_CAPTURED)
+	if captured.CapturedAmount != mustParseMoney(t, "50.00") || captured.TransferID != transfer.ID {
+		t.Errorf("authorization captured %s by %s, want 50.00 by %s", captured.CapturedAmount, captured.TransferID, transfer.ID)
+	}
+
+	//the released 30.00 can be spent again
+	if _, err := s.transfers.CreateInterTransfer("u1", "m1", mustParseMoney(t, "50.00")); err != nil {
+		t.Errorf("transfer of the released credit rejected: %v", err)
+	}
+}
+
+func TestCaptureOfAllTheHold(t *testing.T) {
+
+	s, _ := newAuthorizationServices(t)
+
+	auth, err := s.transfers.Authorize("u1", "m1", mustParseMoney(t, "80.00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transfer, err := s.transfers.CaptureAuthorization(auth.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if transfer.Amount != mustParseMoney(t, "80.00") {
+		t.Errorf("captured %s, want all of 80.00", transfer.Amount)
+	}
+
+	assertCredit(t, s, "80.00", "0")
+}
+
+func TestCaptureOverTheHoldRejected(t *testing.T) {
+
+	s, _ := newAuthorizationServices(t)
+
+	auth, err := s.transfers.Authorize("u1", "m1", mustParseMoney(t, "80.00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.transfers.CaptureAuthorization(auth.ID, mustParseMoney(t, "80.01")); err == nil {
+		t.Fatal("capture over the authorized amount accepted")
+	}
+
+	if _, err := s.transfers.CaptureAuthorization(auth.ID, -1); err == nil {
+		t.Fatal("negative capture accepted")
+	}
+
+	assertCredit(t, s, "0", "80.00")
+	assertAuthorizationState(t, s, auth, model.AUTHORIZATION_PENDING)
+}
+
+func TestVoidReleasesTheHold(t *testing.T) {
+
+	s, _ := newAuthorizationServices(t)
+
+	auth, err := s.transfers.Authorize("u1", "m1", mustParseMoney(t, "80.00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	voided, err := s.transfers.VoidAuthorization(auth.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if voided.State != model.AUTHORIZATION_VOIDED || voided.ClosedAt.IsZero() {
+		t.Errorf("authorization %s closed at %s, want voided", voided.State, voided.ClosedAt)
+	}
+
+	assertCredit(t, s, "0", "0")
+
+	if _, err := s.transfers.CaptureAuthorization(auth.ID, 0); err == nil {
+		t.Error("capture after void accepted")
+	}
+
+	if _, err := s.transfers.VoidAuthorization(auth.ID); err == nil {
+		t.Error("second void accepted")
+	}
+
+	assertCredit(t, s, "0", "0")
+	assertAuthorizationState(t, s, auth, model.AUTHORIZATION_VOIDED)
+}
+
+func TestAuthorizationCapturedOnce(t *testing.T) {
+
+	s, _ := newAuthorizationServices(t)
+
+	auth, err := s.transfers.Authorize("u1", "m1", mustParseMoney(t, "80.00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.transfers.CaptureAuthorization(auth.ID, mustParseMoney(t, "30.00")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.transfers.CaptureAuthorization(auth.ID, mustParseMoney(t, "30.00")); err == nil {
+		t.Error("second capture accepted")
+	}
+
+	if _, err := s.transfers.VoidAuthorization(auth.ID); err == nil {
+		t.Error("void after capture accepted")
+	}
+
+	assertCredit(t, s, "30.00", "0")
+	assertAuthorizationState(t, s, auth, model.AUTHORIZATION_CAPTURED)
+}
+
+func TestExpiryReleasesTheHold(t *testing.T) {
+
+	previous := DefaultHoldDuration()
+	if err := SetDefaultHoldDuration(3 * 24 * time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetDefaultHoldDuration(previous) })
+
+	s, clock := newAuthorizationServices(t)
+
+	auth, err := s.transfers.Authorize("u1", "m1", mustParseMoney(t, "80.00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := clock.Now().Add(3 * 24 * time.Hour); !auth.ExpiresAt.Equal(want) {
+		t.Errorf("expires at %s, want %s", auth.ExpiresAt, want)
+	}
+
+	job := ExpiryJob{Transfers: s.transfers}
+
+	//a day before the hold ends
+	if err := job.Run(clock.Advance(2 * 24 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	assertCredit(t, s, "0", "80.00")
+	assertAuthorizationState(t, s, auth, model.AUTHORIZATION_PENDING)
+
+	//the hold stops reserving credit at its expiry, before the job closes it
+	clock.Advance(24 * time.Hour)
+	assertCredit(t, s, "0", "0")
+
+	if _, err := s.transfers.CaptureAuthorization(auth.ID, 0); err == nil {
+		t.Error("capture of an expired authorization accepted")
+	}
+
+	if err := job.Run(clock.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	expired := assertAuthorizationState(t, s, auth, model.AUTHORIZATION_EXPIRED)
+	if !expired.ClosedAt.Equal(expired.ExpiresAt) {
+		t.Errorf("closed at %s, want its expiry %s", expired.ClosedAt, expired.ExpiresAt)
+	}
+
+	assertCredit(t, s, "0", "0")
+
+	if _, err := s.transfers.CreateInterTransfer("u1", "m1", mustParseMoney(t, "100.00")); err != nil {
+		t.Errorf("transfer of the released credit rejected: %v", err)
+	}
+}
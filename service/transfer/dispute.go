@@ -0,0 +1,267 @@
+package transfer
+
+import (
+	"fmt"
+	"pay-later/integration/log"
+	"pay-later/model"
+
+	"github.com/google/uuid"
+)
+
+// OpenDispute contests amount of the sale of an inter transfer, in the
+// merchant's currency, all that is left of it for a zero amount, and gives
+// the user the disputed part of their charge as a provisional credit. a
+// transfer has one unresolved dispute at a time.
+func (t transferService) OpenDispute(transferID uuid.UUID, amount model.Money, reason string) (*model.Dispute, error) {
+
+	if amount.IsNegative() {
+		return nil, fmt.Errorf("invalid amount")
+	}
+
+	original, err := t.GetInterTransfer(transferID)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := t.usrSrv.LockUser(original.UserName)
+	defer unlock()
+
+	unlockMerchant := t.merchantSrv.LockMerchant(original.MerchantName)
+	defer unlockMerchant()
+
+	tx, err := t.dbSrv.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ledgerSrv := t.ledgerSrv.WithModelManager(tx)
+
+	sale, charged, _, disputed, err := refundLeft(tx, *original, uuid.Nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if disputed > 0 {
+		return nil, fmt.Errorf("transfer already disputed")
+	}
+
+	if sale <= 0 {
+		return nil, fmt.Errorf("transfer already refunded")
+	}
+
+	if amount == 0 {
+		amount = sale
+	}
+
+	if amount > sale {
+		return nil, fmt.Errorf("dispute amount should be less than or equal to what is left of the transfer")
+	}
+
+	credit := charged
+	if amount < sale {
+		if credit, err = refundShare(*original, original.Amount, sale, amount, charged); err != nil {
+			return nil, err
+		}
+	}
+
+	disputeID, err := uuid.NewUUID()
+	if err != nil {
+		return nil, fmt.Errorf("can not able to generate dispute id")
+	}
+
+	currency := original.Currency.OrDefault()
+
+	dModel, err := tx.Upsert(model.Dispute{
+		ID:                disputeID,
+		TransferID:        original.ID,
+		UserName:          original.UserName,
+		MerchantName:      original.MerchantName,
+		Amount:            amount,
+		MerchantCurrency:  original.MerchantCurrency.OrDefault(),
+		ProvisionalCredit: credit,
+		Currency:          currency,
+		State:             model.DISPUTE_OPENED,
+		Reason:            reason,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	dispute, ok := dModel.(model.Dispute)
+	if !ok {
+		return nil, fmt.Errorf("can not able to type assert dispute")
+	}
+
+	//the lender fronts the credit until the dispute is resolved, no money
+	//moves between the user and the merchant before then
+	_, err = ledgerSrv.PostEntry(
+		model.JournalEntry{TransferID: dispute.ID, Type: model.DISPUTE_CREDIT, Currency: currency},
+		model.Debit(model.DisputeSuspense(currency), credit),
+		model.Credit(model.UserReceivable(dispute.UserName, currency), credit),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.l.Error("error committing dispute", log.Fields{"dispute": dispute})
+		return nil, err
+	}
+
+	return &dispute, nil
+}
+
+// ReviewDispute takes an opened dispute under review
+func (t transferService) ReviewDispute(disputeID uuid.UUID) (*model.Dispute, error) {
+
+	dispute, err := t.GetDispute(disputeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if dispute.State != model.DISPUTE_OPENED {
+		return nil, fmt.Errorf("dispute is %s, only an opened dispute can be reviewed", dispute.State)
+	}
+
+	dispute.State = model.DISPUTE_UNDER_REVIEW
+
+	dModel, err := t.dbSrv.Upsert(*dispute)
+	if err != nil {
+		return nil, err
+	}
+
+	nDispute, ok := dModel.(model.Dispute)
+	if !ok {
+		return nil, fmt.Errorf("can not able to type assert dispute")
+	}
+
+	return &nDispute, nil
+}
+
+// ResolveDispute decides an unresolved dispute. either way the provisional
+// credit is reversed; in favour of the user the disputed amount is then
+// charged back to the merchant as a refund of the transfer, in favour of the
+// merchant the user owes it again.
+func (t transferService) ResolveDispute(disputeID uuid.UUID, inFavourOfUser bool) (*model.Dispute, error) {
+
+	dispute, err := t.GetDispute(disputeID)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := t.usrSrv.LockUser(dispute.UserName)
+	defer unlock()
+
+	unlockMerchant := t.merchantSrv.LockMerchant(dispute.MerchantName)
+	defer unlockMerchant()
+
+	tx, err := t.dbSrv.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ledgerSrv := t.ledgerSrv.WithModelManager(tx)
+
+	//read again under the locks, another resolution may have won the race
+	dModel, found, err := tx.GetWithPrimaryKey(model.Dispute{ID: disputeID})
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, fmt.Errorf("dispute not found")
+	}
+
+	current, ok := dModel.(model.Dispute)
+	if !ok {
+		return nil, fmt.Errorf("can not able to type assert dispute")
+	}
+
+	if current.IsResolved() {
+		return nil, fmt.Errorf("dispute already %s", current.State)
+	}
+
+	_, err = ledgerSrv.PostEntry(
+		model.JournalEntry{TransferID: current.ID, Type: model.DISPUTE_REVERSAL, Currency: current.Currency},
+		model.Debit(model.UserReceivable(current.UserName, current.Currency), current.ProvisionalCredit),
+		model.Credit(model.DisputeSuspense(current.Currency), current.ProvisionalCredit),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	current.State = model.DISPUTE_RESOLVED_MERCHANT
+
+	if inFavourOfUser {
+		current.State = model.DISPUTE_RESOLVED_USER
+
+		original, err := t.GetInterTransfer(current.TransferID)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := t.refund(tx, *original, current.Amount, current.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	current.ResolvedAt = tx.Clock().Now()
+
+	rModel, err := tx.Upsert(current)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved, ok := rModel.(model.Dispute)
+	if !ok {
+		return nil, fmt.Errorf("can not able to type assert dispute")
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.l.Error("error committing dispute resolution", log.Fields{"dispute": resolved})
+		return nil, err
+	}
+
+	return &resolved, nil
+}
+
+func (t transferService) GetDispute(disputeID uuid.UUID) (*model.Dispute, error) {
+
+	dModel, found, err := t.dbSrv.GetWithPrimaryKey(model.Dispute{ID: disputeID})
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, fmt.Errorf("dispute not found")
+	}
+
+	dispute, ok := dModel.(model.Dispute)
+	if !ok {
+		return nil, fmt.Errorf("can not able to type assert dispute")
+	}
+
+	return &dispute, nil
+}
+
+// GetDisputes are the disputes of the user, oldest first
+func (t transferService) GetDisputes(userName string) ([]model.Dispute, error) {
+
+	models, err := t.dbSrv.Query(model.Dispute{}, model.NewQuery().Where("UserName", model.Eq, userName).OrderBy("CreatedAt", false))
+	if err != nil {
+		return nil, err
+	}
+
+	var resp = make([]model.Dispute, 0, len(models))
+	for _, m := range models {
+		d, ok := m.(model.Dispute)
+		if !ok {
+			return nil, fmt.Errorf("can not able to type assert dispute")
+		}
+		resp = append(resp, d)
+	}
+
+	return resp, nil
+}
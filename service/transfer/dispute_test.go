@@ -0,0 +1,93 @@
+package transfer
+
+import (
+	"pay-later/model"
+	"strings"
+	"testing"
+)
+
+func TestDisputeCreditMatchesItsChargeback(t *testing.T) {
+
+	policies := []model.RoundingPolicy{model.RoundHalfUp, model.RoundHalfEven, model.RoundFloor, model.RoundCeiling}
+
+	for _, policy := range policies {
+		t.Run(string(policy), func(t *testing.T) {
+
+			s := newTestServices(t)
+
+			if _, err := s.fx.LoadRates(strings.NewReader("USD,JPY,151")); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := s.users.CreateNewUser("u1", "u1@users.com", 100000, "JPY"); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := s.merchants.CreateNewMerchant("m1", "m1@merchants.com", 150, policy, "USD"); err != nil {
+				t.Fatal(err)
+			}
+
+			//a sale of 1.00 USD charged as 151 JPY, whose cents do not divide evenly into yen
+			original, err := s.transfers.CreateInterTransfer("u1", "m1", 100, ConvertCurrency())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var charged model.Money
+			for i := 0; i < 4; i++ {
+				refund, err := s.transfers.RefundInterTransfer(original.ID, 24)
+				if err != nil {
+					t.Fatal(err)
+				}
+				charged += refund.Amount
+			}
+
+			dispute, err := s.transfers.OpenDispute(original.ID, 3, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if left := original.Amount - charged; dispute.ProvisionalCredit > left {
+				t.Fatalf("provisional credit %s over the %s left of the charge", dispute.ProvisionalCredit, left)
+			}
+
+			if _, err := s.transfers.ResolveDispute(dispute.ID, true); err != nil {
+				t.Fatal(err)
+			}
+
+			refunds, err := s.db.Query(model.RefundTransfer{}, model.NewQuery().Where("DisputeID", model.Eq, dispute.ID))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(refunds) != 1 {
+				t.Fatalf("got %d chargebacks, want 1", len(refunds))
+			}
+
+			chargeback := refunds[0].(model.RefundTransfer)
+			if chargeback.Amount != dispute.ProvisionalCredit {
+				t.Errorf("chargeback of %s, provisional credit was %s", chargeback.Amount, dispute.ProvisionalCredit)
+			}
+			charged += chargeback.Amount
+
+			last, err := s.transfers.RefundInterTransfer(original.ID, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			charged += last.Amount
+
+			if charged != original.Amount {
+				t.Errorf("refunds took back %s of %s", charged, original.Amount)
+			}
+
+			dues, err := s.users.GetUserDues("u1")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if dues != 0 {
+				t.Errorf("dues %s after a full refund", dues)
+			}
+		})
+	}
+}
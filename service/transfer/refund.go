@@ -0,0 +1,319 @@
+package transfer
+
+import (
+	"fmt"
+	"pay-later/integration/log"
+	"pay-later/model"
+
+	"github.com/google/uuid"
+)
+
+// GetInterTransfer is the inter transfer with the id
+func (t transferService) GetInterTransfer(id uuid.UUID) (*model.InterTransfer, error) {
+
+	tModel, found, err := t.dbSrv.GetWithPrimaryKey(model.InterTransfer{ID: id})
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, fmt.Errorf("transfer not found")
+	}
+
+	transfer, ok := tModel.(model.InterTransfer)
+	if !ok {
+		return nil, fmt.Errorf("can not able to type assert transfer")
+	}
+
+	return &transfer, nil
+}
+
+// RefundInterTransfer reverses amount of the sale of an inter transfer, in
+// the merchant's currency, all that is left of it for a zero amount. what is
+// contested by an unresolved dispute is not left to refund.
+func (t transferService) RefundInterTransfer(transferID uuid.UUID, amount model.Money) (*model.RefundTransfer, error) {
+
+	if amount.IsNegative() {
+		return nil, fmt.Errorf("invalid amount")
+	}
+
+	original, err := t.GetInterTransfer(transferID)
+	if err != nil {
+		return nil, err
+	}
+
+	//always the user before the merchant, so that transfers can not deadlock
+	unlock := t.usrSrv.LockUser(original.UserName)
+	defer unlock()
+
+	unlockMerchant := t.merchantSrv.LockMerchant(original.MerchantName)
+	defer unlockMerchant()
+
+	tx, err := t.dbSrv.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	refund, err := t.refund(tx, *original, amount, uuid.Nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.l.Error("error committing refund", log.Fields{"transfer": refund})
+		return nil, err
+	}
+
+	return refund, nil
+}
+
+// refundLeft is what is left of the sale, the charge and the discount of
+// original once its refunds are taken off, and how much of the sale is
+// contested by unresolved disputes other than disputeID
+func refundLeft(db model.ModelManager, original model.InterTransfer, disputeID uuid.UUID) (sale model.Money, charged model.Money, discount model.Money, disputed model.Money, err error) {
+
+	refunds, err := db.Query(model.RefundTransfer{}, model.NewQuery().Where("TransferID", model.Eq, original.ID))
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+
+	sale, charged, discount = original.SaleAmount(), original.Amount, original.DiscountAmount
+	for _, m := range refunds {
+		r, ok := m.(model.RefundTransfer)
+		if !ok {
+			return 0, 0, 0, 0, fmt.Errorf("can not able to type assert transfer")
+		}
+
+		for _, err := range []error{
+			subFrom(&sale, r.MerchantAmount),
+			subFrom(&charged, r.Amount),
+			subFrom(&discount, r.DiscountAmount),
+		} {
+			if err != nil {
+				return 0, 0, 0, 0, err
+			}
+		}
+	}
+
+	disputes, err := db.Query(model.Dispute{}, model.NewQuery().Where("TransferID", model.Eq, original.ID))
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+
+	for _, m := range disputes {
+		d, ok := m.(model.Dispute)
+		if !ok {
+			return 0, 0, 0, 0, fmt.Errorf("can not able to type assert dispute")
+		}
+
+		if d.ID == disputeID || d.IsResolved() {
+			continue
+		}
+
+		if disputed, err = disputed.Add(d.Amount); err != nil {
+			return 0, 0, 0, 0, err
+		}
+	}
+
+	return sale, charged, discount, disputed, nil
+}
+
+// refund writes and posts a refund of amount of original in tx, crediting
+// the user and clawing back the merchant's discount in proportion, rounded
+// by the policy of the transfer. the refund that takes the last of the sale
+// takes exactly what is left of both so that nothing is left over.
+func (t transferService) refund(tx model.Tx, original model.InterTransfer, amount model.Money, disputeID uuid.UUID) (*model.RefundTransfer, error) {
+
+	txnSrv := t.txnService.WithModelManager(tx)
+	ledgerSrv := t.ledgerSrv.WithModelManager(tx)
+
+	sale, charged, discount, disputed, err := refundLeft(tx, original, disputeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if sale <= 0 {
+		return nil, fmt.Errorf("transfer already refunded")
+	}
+
+	refundable, err := sale.Sub(disputed)
+	if err != nil {
+		return nil, err
+	}
+
+	if amount == 0 {
+		amount = refundable
+	}
+
+	if amount <= 0 || amount > refundable {
+		return nil, fmt.Errorf("refund amount should be less than or equal to what is left of the transfer")
+	}
+
+	refundCharged, refundDiscount := charged, discount
+	if amount < sale {
+		if refundCharged, err = refundShare(original, original.Amount, sale, amount, charged); err != nil {
+			return nil, err
+		}
+
+		if refundDiscount, err = refundShare(original, original.DiscountAmount, sale, amount, discount); err != nil {
+			return nil, err
+		}
+	}
+
+	net, err := amount.Sub(refundDiscount)
+	if err != nil {
+		return nil, err
+	}
+
+	userCurrency, merchantCurrency := original.Currency.OrDefault(), original.MerchantCurrency.OrDefault()
+
+	refundID, err := uuid.NewUUID()
+	if err != nil {
+		return nil, fmt.Errorf("can not able to generate transfer id")
+	}
+
+	rModel, err := tx.Upsert(model.RefundTransfer{
+		ID:               refundID,
+		TransferID:       original.ID,
+		UserName:         original.UserName,
+		MerchantName:     original.MerchantName,
+		Amount:           refundCharged,
+		Currency:         userCurrency,
+		MerchantAmount:   amount,
+		MerchantCurrency: merchantCurrency,
+		DiscountAmount:   refundDiscount,
+		DisputeID:        disputeID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	refund, ok := rModel.(model.RefundTransfer)
+	if !ok {
+		return nil, fmt.Errorf("can not able to type assert model")
+	}
+
+	//the purchase posted backwards. a refund too small to be worth a minor
+	//unit of a converted charge leaves the user side as it is
+	if refundCharged > 0 {
+		_, err = ledgerSrv.PostEntry(
+			model.JournalEntry{TransferID: refund.ID, Type: model.MERCHANT_REFUND, Currency: userCurrency},
+			model.Debit(model.ClearingAccount(userCurrency), refundCharged),
+			model.Credit(model.UserReceivable(refund.UserName, userCurrency), refundCharged),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = ledgerSrv.PostEntry(
+		model.JournalEntry{TransferID: refund.ID, Type: model.MERCHANT_REFUND, Currency: merchantCurrency},
+		model.Debit(model.MerchantPayable(refund.MerchantName, merchantCurrency), net),
+		model.Debit(model.LenderRevenue(merchantCurrency), refundDiscount),
+		model.Credit(model.ClearingAccount(merchantCurrency), amount),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	//compensating transactions, against the transfer they reverse
+	txn1Id, err := uuid.NewUUID()
+	if err != nil {
+		return nil, fmt.Errorf("can not able to generate transaction id")
+	}
+
+	txn2Id, err := uuid.NewUUID()
+	if err != nil {
+		return nil, fmt.Errorf("can not able to generate transaction id")
+	}
+
+	_, err = txnSrv.CreateTransaction(&model.Transaction{
+		ID:              txn1Id,
+		TransferID:      original.ID,
+		Type:            model.MERCHANT_REFUND,
+		SourceName:      refund.MerchantName,
+		DestinationName: refund.UserName,
+		Amount:          net,
+		Currency:        merchantCurrency,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = txnSrv.CreateTransaction(&model.Transaction{
+		ID:              txn2Id,
+		TransferID:      original.ID,
+		Type:            model.DISCOUNT_CLAWBACK,
+		SourceName:      model.CLEARING_ACCOUNT_NAME,
+		DestinationName: refund.MerchantName,
+		Amount:          refundDiscount,
+		Currency:        merchantCurrency,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &refund, nil
+}
+
+// refundShare is the part of total, the charge or the discount of original,
+// that refunding amount of what is left of its sale, saleLeft, takes back.
+// it is prorated cumulatively, the share of everything refunded so far with
+// amount less the share without it, so that rounding never makes the parts
+// add up to more than total, and is never more than left of it.
+func refundShare(original model.InterTransfer, total model.Money, saleLeft model.Money, amount model.Money, left model.Money) (model.Money, error) {
+
+	policy := original.Rounding
+	if policy == "" {
+		policy = model.DefaultRoundingPolicy()
+	}
+
+	whole := original.SaleAmount()
+
+	refunded, err := whole.Sub(saleLeft)
+	if err != nil {
+		return 0, err
+	}
+
+	upto, err := refunded.Add(amount)
+	if err != nil {
+		return 0, err
+	}
+
+	after, err := policy.Prorate(total, upto, whole)
+	if err != nil {
+		return 0, err
+	}
+
+	before, err := policy.Prorate(total, refunded, whole)
+	if err != nil {
+		return 0, err
+	}
+
+	share, err := after.Sub(before)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case share > left:
+		share = left
+	case share.IsNegative():
+		share = 0
+	}
+
+	return share, nil
+}
+
+// subFrom takes amount off what *left is
+func subFrom(left *model.Money, amount model.Money) error {
+
+	v, err := left.Sub(amount)
+	if err != nil {
+		return err
+	}
+
+	*left = v
+	return nil
+}
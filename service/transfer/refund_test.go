@@ -0,0 +1,89 @@
+package transfer
+
+import (
+	"fmt"
+	"pay-later/model"
+	"pay-later/service/transaction"
+	"testing"
+)
+
+func TestPartialRefundsAddUpToTheTransfer(t *testing.T) {
+
+	policies := []model.RoundingPolicy{model.RoundHalfUp, model.RoundHalfEven, model.RoundFloor, model.RoundCeiling}
+
+	sales := []struct {
+		sale     string
+		discount int //basis points
+		refunds  []string
+	}{
+		{"1.00", 100, []string{"0.50", "0.49"}},
+		{"10.01", 150, []string{"0.33", "0.33", "0.33", "3.00", "0.01"}},
+		{"0.07", 250, []string{"0.01", "0.01", "0.01", "0.01"}},
+	}
+
+	for _, policy := range policies {
+		for i, sc := range sales {
+			t.Run(fmt.Sprintf("%s/%s", policy, sc.sale), func(t *testing.T) {
+
+				s := newTestServices(t)
+
+				if _, err := s.users.CreateNewUser("u1", "u1@users.com", mustParseMoney(t, "100.00"), ""); err != nil {
+					t.Fatal(err)
+				}
+
+				merchant := fmt.Sprintf("m%d", i)
+				if _, err := s.merchants.CreateNewMerchant(merchant, merchant+"@merchants.com", sc.discount, policy, ""); err != nil {
+					t.Fatal(err)
+				}
+
+				original, err := s.transfers.CreateInterTransfer("u1", merchant, mustParseMoney(t, sc.sale))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var charged, discount model.Money
+
+				//the partial refunds, then all that is left
+				for _, amount := range append(sc.refunds, "0") {
+					refund, err := s.transfers.RefundInterTransfer(original.ID, mustParseMoney(t, amount))
+					if err != nil {
+						t.Fatalf("refund of %s: %v", amount, err)
+					}
+
+					if refund.Amount.IsNegative() || refund.DiscountAmount.IsNegative() {
+						t.Fatalf("refund of %s took back %s and a discount of %s", amount, refund.Amount, refund.DiscountAmount)
+					}
+
+					charged += refund.Amount
+					discount += refund.DiscountAmount
+
+					if charged > original.Amount || discount > original.DiscountAmount {
+						t.Fatalf("refunds took back %s and a discount of %s of %s and %s", charged, discount, original.Amount, original.DiscountAmount)
+					}
+				}
+
+				if charged != original.Amount || discount != original.DiscountAmount {
+					t.Errorf("refunds took back %s and a discount of %s, want %s and %s", charged, discount, original.Amount, original.DiscountAmount)
+				}
+
+				dues, err := s.users.GetUserDues("u1")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if dues != 0 {
+					t.Errorf("dues %s after a full refund", dues)
+				}
+
+				totals, err := transaction.NewTransactionService(s.db, nil).GetMerchantTotals(merchant)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if totals.DiscountEarned != 0 || totals.NetPaid != 0 || totals.GrossVolume != 0 {
+					t.Errorf("merchant totals %+v after a full refund", totals)
+				}
+			})
+		}
+	}
+}
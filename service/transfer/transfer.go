@@ -4,16 +4,35 @@ import (
 	"fmt"
 	"pay-later/integration/log"
 	"pay-later/model"
+	"pay-later/service/fx"
+	"pay-later/service/ledger"
 	"pay-later/service/merchant"
 	"pay-later/service/transaction"
 	"pay-later/service/user"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 type TransferService interface {
-	CreateInterTransfer(string, string, float64) (*model.InterTransfer, error)
-	CreatePaybackTransfer(string, float64) (*model.UserPaybackTransfer, error)
+	CreateInterTransfer(string, string, model.Money, ...TransferOption) (*model.InterTransfer, error)
+	CreatePaybackTransfer(string, model.Money, ...TransferOption) (*model.UserPaybackTransfer, error)
+	RefundCredit(string, model.Money) (*model.CreditRefundTransfer, error)
+	RefundInterTransfer(uuid.UUID, model.Money) (*model.RefundTransfer, error)
+	GetInterTransfer(uuid.UUID) (*model.InterTransfer, error)
+	OpenDispute(uuid.UUID, model.Money, string) (*model.Dispute, error)
+	ReviewDispute(uuid.UUID) (*model.Dispute, error)
+	ResolveDispute(uuid.UUID, bool) (*model.Dispute, error)
+	GetDispute(uuid.UUID) (*model.Dispute, error)
+	GetDisputes(string) ([]model.Dispute, error)
+	Authorize(string, string, model.Money, ...TransferOption) (*model.Authorization, error)
+	CaptureAuthorization(uuid.UUID, model.Money) (*model.InterTransfer, error)
+	VoidAuthorization(uuid.UUID) (*model.Authorization, error)
+	ExpireAuthorizations(time.Time) ([]model.Authorization, error)
+	GetAuthorization(uuid.UUID) (*model.Authorization, error)
+	GetAuthorizations(string) ([]model.Authorization, error)
+	GetOpenCharges(string) ([]OpenCharge, error)
+	GetAllocations(uuid.UUID) ([]model.PaybackAllocation, error)
 }
 
 type transferService struct {
@@ -22,25 +41,122 @@ type transferService struct {
 	dbSrv       model.ModelManager
 	usrSrv      user.UserService
 	merchantSrv merchant.MerchantService
+	fxSrv       fx.FXService
+	ledgerSrv   ledger.LedgerService
 }
 
-func NewTransferService(l log.Logger, txnSrv transaction.TransactionService, usrSrv user.UserService, merchantSrv merchant.MerchantService, dbSrv model.ModelManager) TransferService {
+type transferOpts struct {
+	convert bool
+	overpay bool
+}
+
+type TransferOption func(*transferOpts)
+
+// ConvertCurrency allows a transfer between a user and a merchant with
+// different currencies, charging the user the sale converted at the loaded
+// fx rate
+func ConvertCurrency() TransferOption {
+	return func(o *transferOpts) {
+		o.convert = true
+	}
+}
+
+// AcceptOverpayment allows a payback larger than the dues, or with no dues at
+// all, the excess becoming a credit balance that pays later charges
+func AcceptOverpayment() TransferOption {
+	return func(o *transferOpts) {
+		o.overpay = true
+	}
+}
+
+func NewTransferService(l log.Logger, txnSrv transaction.TransactionService, usrSrv user.UserService, merchantSrv merchant.MerchantService, fxSrv fx.FXService, ledgerSrv ledger.LedgerService, dbSrv model.ModelManager) TransferService {
 	return &transferService{
-		l, txnSrv, dbSrv, usrSrv, merchantSrv,
+		l, txnSrv, dbSrv, usrSrv, merchantSrv, fxSrv, ledgerSrv,
 	}
 }
 
-func (t transferService) CreateInterTransfer(userName string, merchantName string, amount float64) (*model.InterTransfer, error) {
+// CreateInterTransfer records a sale of amount, in the merchant's currency,
+// charged to the user. when the user has another currency the transfer is
+// rejected unless ConvertCurrency is given.
+func (t transferService) CreateInterTransfer(userName string, merchantName string, amountToTransfer model.Money, opts ...TransferOption) (*model.InterTransfer, error) {
 
-	user, err := t.usrSrv.GetUserWithName(userName)
-	if err != nil || user == nil {
+	var o transferOpts
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if amountToTransfer <= 0 {
+		return nil, fmt.Errorf("amount should be greater than zero")
+	}
+
+	//always the user before the merchant, so that transfers can not deadlock
+	unlock := t.usrSrv.LockUser(userName)
+	defer unlock()
+
+	unlockMerchant := t.merchantSrv.LockMerchant(merchantName)
+	defer unlockMerchant()
+
+	tx, err := t.dbSrv.Begin()
+	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback()
 
-	amountToTransfer := int(amount * 100) //store it as cents
+	nTransfer, err := t.interTransfer(tx, userName, merchantName, amountToTransfer, o, 0)
+	if err != nil || nTransfer == nil {
+		return nil, err
+	}
 
-	if !user.AllowAmount(amountToTransfer) {
-		return nil, fmt.Errorf("credit limit reached")
+	if err := tx.Commit(); err != nil {
+		t.l.Error("error committing transfer", log.Fields{"transfer": nTransfer})
+		return nil, err
+	}
+
+	return nTransfer, nil
+
+}
+
+// charge is what the user is charged for a sale of amount by the merchant,
+// converted to the user's currency at the loaded fx rate when ConvertCurrency
+// was given, along with that rate, 0 when both currencies are the same
+func (t transferService) charge(user model.User, merchant model.Merchant, amount model.Money, o transferOpts) (model.Money, int64, error) {
+
+	userCurrency, merchantCurrency := user.Currency.OrDefault(), merchant.Currency.OrDefault()
+
+	if userCurrency == merchantCurrency {
+		return amount, 0, nil
+	}
+
+	if !o.convert {
+		return 0, 0, fmt.Errorf("currency mismatch: user pays in %s, merchant settles in %s", userCurrency, merchantCurrency)
+	}
+
+	rate, err := t.fxSrv.GetRate(merchantCurrency, userCurrency)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	charged, err := rate.Convert(amount, merchant.RoundingPolicy())
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return charged, rate.Rate, nil
+}
+
+// interTransfer writes the transfer, its journal entries and transactions to
+// tx. the user is charged against their credit limit less reserved, what a
+// captured authorization already held for it.
+func (t transferService) interTransfer(tx model.Tx, userName string, merchantName string, amountToTransfer model.Money, o transferOpts, reserved model.Money) (*model.InterTransfer, error) {
+
+	//the transfer, the journal entries and both ledger rows are written all or none
+	usrSrv := t.usrSrv.WithModelManager(tx)
+	txnSrv := t.txnService.WithModelManager(tx)
+	ledgerSrv := t.ledgerSrv.WithModelManager(tx)
+
+	user, err := usrSrv.GetUserWithName(userName)
+	if err != nil || user == nil {
+		return nil, err
 	}
 
 	merchant, err := t.merchantSrv.GetMerchantWithName(merchantName)
@@ -48,10 +164,41 @@ func (t transferService) CreateInterTransfer(userName string, merchantName strin
 		return nil, err
 	}
 
-	discount := merchant.GetDiscountedAmount(amountToTransfer)
-	discountedAmount := int(discount) // truncarte the 2 decimal place for cents
+	userCurrency, merchantCurrency := user.Currency.OrDefault(), merchant.Currency.OrDefault()
+
+	chargedAmount, fxRate, err := t.charge(*user, *merchant, amountToTransfer, o)
+	if err != nil {
+		return nil, err
+	}
+
+	dues, err := ledgerSrv.GetUserDues(user.Name, userCurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	held, err := usrSrv.GetHeldAmount(user.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if dues, err = dues.Add(held); err != nil {
+		return nil, err
+	}
+
+	//a capture was already allowed what it reserved
+	if chargedAmount > reserved && !user.AllowAmount(dues, chargedAmount-reserved) {
+		return nil, fmt.Errorf("credit limit reached")
+	}
+
+	discountedAmount, residual, err := merchant.GetDiscountedAmount(amountToTransfer)
+	if err != nil {
+		return nil, err
+	}
 
-	actualTransferAmount := amountToTransfer - discountedAmount
+	actualTransferAmount, err := amountToTransfer.Sub(discountedAmount)
+	if err != nil {
+		return nil, err
+	}
 
 	transferId, err := uuid.NewUUID()
 	if err != nil {
@@ -59,14 +206,21 @@ func (t transferService) CreateInterTransfer(userName string, merchantName strin
 	}
 
 	transfer := model.InterTransfer{
-		ID:             transferId,
-		UserName:       user.Name,
-		MerchantName:   merchant.Name,
-		Amount:         amountToTransfer,
-		DiscountAmount: discountedAmount,
+		ID:               transferId,
+		UserName:         user.Name,
+		MerchantName:     merchant.Name,
+		Amount:           chargedAmount,
+		Currency:         userCurrency,
+		MerchantAmount:   amountToTransfer,
+		MerchantCurrency: merchantCurrency,
+		FXRate:           fxRate,
+		DiscountAmount:   discountedAmount,
+		DiscountRate:     merchant.Discount,
+		Rounding:         merchant.RoundingPolicy(),
+		Residual:         residual,
 	}
 
-	_, found, err := t.dbSrv.GetWithPrimaryKey(transfer)
+	_, found, err := tx.GetWithPrimaryKey(transfer)
 	if err != nil {
 		return nil, err
 	}
@@ -75,7 +229,7 @@ func (t transferService) CreateInterTransfer(userName string, merchantName strin
 		return nil, fmt.Errorf("transfer already exist")
 	}
 
-	tnsfrM, err := t.dbSrv.Upsert(transfer)
+	tnsfrM, err := tx.Upsert(transfer)
 	if err != nil {
 		t.l.Error("error initiating transfer", log.Fields{"transfer": transfer})
 		return nil, err
@@ -86,9 +240,23 @@ func (t transferService) CreateInterTransfer(userName string, merchantName strin
 		return nil, fmt.Errorf("can not able to type assert")
 	}
 
-	//update the user dues
-	resultantDues := user.Dues + amountToTransfer
-	_, err = t.usrSrv.UpdateUserDues(user.Name, resultantDues)
+	//the user owes the charge, which reaches the merchant side through the
+	//clearing account of each currency, less the discount the lender earns
+	_, err = ledgerSrv.PostEntry(
+		model.JournalEntry{TransferID: nTransfer.ID, Type: model.USER_MERCHANT_TRANSFER, Currency: userCurrency},
+		model.Debit(model.UserReceivable(user.Name, userCurrency), chargedAmount),
+		model.Credit(model.ClearingAccount(userCurrency), chargedAmount),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = ledgerSrv.PostEntry(
+		model.JournalEntry{TransferID: nTransfer.ID, Type: model.USER_MERCHANT_TRANSFER, Currency: merchantCurrency},
+		model.Debit(model.ClearingAccount(merchantCurrency), amountToTransfer),
+		model.Credit(model.MerchantPayable(merchant.Name, merchantCurrency), actualTransferAmount),
+		model.Credit(model.LenderRevenue(merchantCurrency), discountedAmount),
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -113,6 +281,7 @@ func (t transferService) CreateInterTransfer(userName string, merchantName strin
 		SourceName:      nTransfer.UserName,
 		DestinationName: nTransfer.MerchantName,
 		Amount:          actualTransferAmount,
+		Currency:        merchantCurrency,
 	}
 
 	txn2 := model.Transaction{
@@ -122,44 +291,67 @@ func (t transferService) CreateInterTransfer(userName string, merchantName strin
 		SourceName:      nTransfer.MerchantName,
 		DestinationName: model.CLEARING_ACCOUNT_NAME,
 		Amount:          discountedAmount,
+		Currency:        merchantCurrency,
 	}
 
-	_, err = t.txnService.CreateTransaction(&txn1)
+	_, err = txnSrv.CreateTransaction(&txn1)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = t.txnService.CreateTransaction(&txn2)
+	_, err = txnSrv.CreateTransaction(&txn2)
 	if err != nil {
 		return nil, err
 	}
 
 	return &nTransfer, nil
-
 }
 
-func (t transferService) CreatePaybackTransfer(userName string, amount float64) (*model.UserPaybackTransfer, error) {
+// CreatePaybackTransfer pays back amount of the dues of the user, allocated
+// to fees, then interest, then principal, oldest charge first. more than the
+// dues is rejected unless AcceptOverpayment is given.
+func (t transferService) CreatePaybackTransfer(userName string, amountToTransfer model.Money, opts ...TransferOption) (*model.UserPaybackTransfer, error) {
 
-	user, err := t.usrSrv.GetUserWithName(userName)
-	if err != nil || user == nil {
-		return nil, err
+	var o transferOpts
+	for _, opt := range opts {
+		opt(&o)
 	}
 
-	amountToTransfer := int(amount * 100) //store it as cents
+	unlock := t.usrSrv.LockUser(userName)
+	defer unlock()
 
-	if user.Dues <= 0 {
-		return nil, fmt.Errorf("no dues for user")
+	tx, err := t.dbSrv.Begin()
+	if err != nil {
+		return nil, err
 	}
+	defer tx.Rollback()
 
-	if amountToTransfer > user.Dues {
-		return nil, fmt.Errorf("payback amount should be less than or equal to dues")
+	usrSrv := t.usrSrv.WithModelManager(tx)
+	txnSrv := t.txnService.WithModelManager(tx)
+	ledgerSrv := t.ledgerSrv.WithModelManager(tx)
+
+	nUser, err := usrSrv.GetUserWithName(userName)
+	if err != nil || nUser == nil {
+		return nil, err
 	}
 
-	finalDues := user.Dues - amountToTransfer
+	currency := nUser.Currency.OrDefault()
 
-	nUser, err := t.usrSrv.UpdateUserDues(user.Name, finalDues)
+	dues, err := ledgerSrv.GetUserDues(nUser.Name, currency)
 	if err != nil {
-		return nil, fmt.Errorf("can not able to change dues for user: %s", user.Name)
+		return nil, err
+	}
+
+	if amountToTransfer <= 0 {
+		return nil, fmt.Errorf("amount should be greater than zero")
+	}
+
+	if dues <= 0 && !o.overpay {
+		return nil, fmt.Errorf("no dues for user")
+	}
+
+	if amountToTransfer > dues && !o.overpay {
+		return nil, fmt.Errorf("payback amount should be less than or equal to dues")
 	}
 
 	transferID, err := uuid.NewUUID()
@@ -171,9 +363,10 @@ func (t transferService) CreatePaybackTransfer(userName string, amount float64)
 		ID:       transferID,
 		UserName: nUser.Name,
 		Amount:   amountToTransfer,
+		Currency: currency,
 	}
 
-	_, found, err := t.dbSrv.GetWithPrimaryKey(transfer)
+	_, found, err := tx.GetWithPrimaryKey(transfer)
 	if err != nil {
 		return nil, fmt.Errorf("can not able to check if transfer already exist")
 	}
@@ -182,7 +375,13 @@ func (t transferService) CreatePaybackTransfer(userName string, amount float64)
 		return nil, fmt.Errorf("transfer already exist")
 	}
 
-	nTransfer, err := t.dbSrv.Upsert(transfer)
+	//allocated before the payback is written, so that it is not taken for
+	//one made before allocations were recorded
+	if _, err := allocate(tx, transfer); err != nil {
+		return nil, err
+	}
+
+	nTransfer, err := tx.Upsert(transfer)
 	if err != nil {
 		return nil, err
 	}
@@ -204,12 +403,158 @@ func (t transferService) CreatePaybackTransfer(userName string, amount float64)
 		SourceName:      model.USER_PAYBACK_ACCOUNT_NAME,
 		DestinationName: nTrans.UserName,
 		Amount:          amountToTransfer,
+		Currency:        nTrans.Currency,
 	}
 
-	_, err = t.txnService.CreateTransaction(&txn)
+	_, err = txnSrv.CreateTransaction(&txn)
 	if err != nil {
 		return nil, err
 	}
 
+	_, err = ledgerSrv.PostEntry(
+		model.JournalEntry{TransferID: nTrans.ID, Type: model.USER_PAYBACK_TRANSFER, Currency: currency},
+		model.Debit(model.ExternalAccount(currency), amountToTransfer),
+		model.Credit(model.UserReceivable(nTrans.UserName, currency), amountToTransfer),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.l.Error("error committing transfer", log.Fields{"transfer": nTrans})
+		return nil, err
+	}
+
 	return &nTrans, nil
 }
+
+// RefundCredit sends amount of the credit balance of the user back to where
+// it was paid from, all of it for a zero amount
+func (t transferService) RefundCredit(userName string, amount model.Money) (*model.CreditRefundTransfer, error) {
+
+	if amount.IsNegative() {
+		return nil, fmt.Errorf("invalid amount")
+	}
+
+	unlock := t.usrSrv.LockUser(userName)
+	defer unlock()
+
+	tx, err := t.dbSrv.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	usrSrv := t.usrSrv.WithModelManager(tx)
+	txnSrv := t.txnService.WithModelManager(tx)
+	ledgerSrv := t.ledgerSrv.WithModelManager(tx)
+
+	nUser, err := usrSrv.GetUserWithName(userName)
+	if err != nil || nUser == nil {
+		return nil, err
+	}
+
+	currency := nUser.Currency.OrDefault()
+
+	dues, err := ledgerSrv.GetUserDues(nUser.Name, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	if dues >= 0 {
+		return nil, fmt.Errorf("no credit balance for user")
+	}
+
+	credit := -dues
+	if amount == 0 {
+		amount = credit
+	}
+
+	if amount > credit {
+		return nil, fmt.Errorf("refund amount should be less than or equal to credit balance")
+	}
+
+	paybackID, err := t.lastOverpayment(tx, nUser.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	transferID, err := uuid.NewUUID()
+	if err != nil {
+		return nil, fmt.Errorf("can not able to generate transfer id")
+	}
+
+	rModel, err := tx.Upsert(model.CreditRefundTransfer{
+		ID:        transferID,
+		UserName:  nUser.Name,
+		PaybackID: paybackID,
+		Amount:    amount,
+		Currency:  currency,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	refund, ok := rModel.(model.CreditRefundTransfer)
+	if !ok {
+		return nil, fmt.Errorf("can not able to type assert model")
+	}
+
+	txnID, err := uuid.NewUUID()
+	if err != nil {
+		return nil, fmt.Errorf("can not able to generate transaction id")
+	}
+
+	_, err = txnSrv.CreateTransaction(&model.Transaction{
+		ID:              txnID,
+		TransferID:      refund.ID,
+		Type:            model.CREDIT_REFUND,
+		SourceName:      refund.UserName,
+		DestinationName: model.USER_PAYBACK_ACCOUNT_NAME,
+		Amount:          amount,
+		Currency:        currency,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	//the reverse of a payback, the credit goes back out of the external account
+	_, err = ledgerSrv.PostEntry(
+		model.JournalEntry{TransferID: refund.ID, Type: model.CREDIT_REFUND, Currency: currency},
+		model.Debit(model.UserReceivable(refund.UserName, currency), amount),
+		model.Credit(model.ExternalAccount(currency), amount),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.l.Error("error committing credit refund", log.Fields{"transfer": refund})
+		return nil, err
+	}
+
+	return &refund, nil
+}
+
+// lastOverpayment is the last payback of the user with an unapplied part,
+// uuid.Nil when there is none
+func (t transferService) lastOverpayment(db model.ModelManager, userName string) (uuid.UUID, error) {
+
+	models, err := db.Query(model.PaybackAllocation{}, model.NewQuery().Where("UserName", model.Eq, userName).OrderBy("PostedAt", true))
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	for _, m := range models {
+		a, ok := m.(model.PaybackAllocation)
+		if !ok {
+			return uuid.Nil, fmt.Errorf("can not able to type assert allocation")
+		}
+
+		if a.Bucket == model.UNAPPLIED_ALLOCATION {
+			return a.TransferID, nil
+		}
+	}
+
+	return uuid.Nil, nil
+}
@@ -0,0 +1,146 @@
+package transfer
+
+import (
+	"io/ioutil"
+	"pay-later/integration/email"
+	"pay-later/integration/log"
+	"pay-later/model"
+	"pay-later/service/fx"
+	"pay-later/service/ledger"
+	"pay-later/service/merchant"
+	"pay-later/service/transaction"
+	"pay-later/service/user"
+	"sync"
+	"testing"
+)
+
+type testServices struct {
+	db        model.ModelManager
+	users     user.UserService
+	merchants merchant.MerchantService
+	fx        fx.FXService
+	transfers TransferService
+}
+
+// newTestServices wires the services over an in-memory model manager
+func newTestServices(t *testing.T, opts ...model.Option) testServices {
+	t.Helper()
+
+	l := log.NewLogger(log.SetOutput(ioutil.Discard))
+
+	db, err := model.NewModelManager(l, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	emailSrv := email.NewEmailService(l)
+	txnSrv := transaction.NewTransactionService(db, l)
+	usrSrv := user.NewUserService(db, emailSrv, l)
+	mrtSrv := merchant.NewMerchantService(db, emailSrv, l)
+	fxSrv := fx.NewFXService(l, db)
+
+	return testServices{
+		db:        db,
+		users:     usrSrv,
+		merchants: mrtSrv,
+		fx:        fxSrv,
+		transfers: NewTransferService(l, txnSrv, usrSrv, mrtSrv, fxSrv, ledger.NewLedgerService(db, l), db),
+	}
+}
+
+func mustParseMoney(t *testing.T, s string) model.Money {
+	t.Helper()
+
+	m, err := model.ParseMoney(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestConcurrentTransfersStayWithinCreditLimit(t *testing.T) {
+
+	s := newTestServices(t)
+
+	limit, amount := mustParseMoney(t, "100.00"), mustParseMoney(t, "7.00")
+
+	if _, err := s.users.CreateNewUser("u1", "u1@users.com", limit, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	merchants := []string{"m1", "m2"}
+	for _, name := range merchants {
+		if _, err := s.merchants.CreateNewMerchant(name, name+"@merchants.com", 150, "", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const goroutines = 64
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		accepted int
+	)
+
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			//every few goroutines rewrite the user row under the transfers
+			if i%8 == 0 {
+				if _, err := s.users.ChangeCreditLimit("u1", limit); err != nil {
+					t.Errorf("change credit limit: %v", err)
+				}
+			}
+
+			if _, err := s.transfers.CreateInterTransfer("u1", merchants[i%len(merchants)], amount); err != nil {
+				if err.Error() != "credit limit reached" {
+					t.Errorf("transfer %d: %v", i, err)
+				}
+				return
+			}
+
+			dues, err := s.users.GetUserDues("u1")
+			if err != nil {
+				t.Errorf("dues: %v", err)
+				return
+			}
+
+			if dues > limit {
+				t.Errorf("dues %s over the credit limit %s", dues, limit)
+			}
+
+			mu.Lock()
+			accepted++
+			mu.Unlock()
+		}(i)
+	}
+
+	wg.Wait()
+
+	dues, err := s.users.GetUserDues("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := amount.Mul(int64(accepted))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dues != want {
+		t.Errorf("dues %s, want %d transfers of %s = %s", dues, accepted, amount, want)
+	}
+
+	if dues > limit {
+		t.Errorf("dues %s over the credit limit %s", dues, limit)
+	}
+
+	if max := int(limit / amount); accepted != max {
+		t.Errorf("accepted %d transfers, want %d", accepted, max)
+	}
+}
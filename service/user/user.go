@@ -3,37 +3,90 @@ package user
 import (
 	"fmt"
 	"pay-later/integration/email"
+	"pay-later/integration/lock"
 	"pay-later/integration/log"
 	"pay-later/model"
+	"pay-later/service/ledger"
 )
 
 type UserService interface {
-	ChangeCreditLimit(string, float64) (*model.User, error)
+	ChangeCreditLimit(string, model.Money) (*model.User, error)
 	GetUserWithName(string) (*model.User, error)
-	CreateNewUser(string, string, float64) (*model.User, error)
-	UpdateUserDues(string, int) (*model.User, error)
+	CreateNewUser(string, string, model.Money, model.Currency) (*model.User, error)
+	GetUserDues(string) (model.Money, error)
+	GetHeldAmount(string) (model.Money, error)
+	GetAvailableCredit(string) (model.Money, model.Money, error)
 	GetCreditLimitUsers() ([]*model.User, error)
-	GetTotalDues() ([]*model.User, error)
+	GetTotalDues() ([]UserDues, error)
+	WithModelManager(model.ModelManager) UserService
+	LockUser(string) func()
+	DeleteUser(string) error
 }
 
+// UserDues is a user with what they owe
+type UserDues struct {
+	User model.User
+	Dues model.Money
+}
+
+// userLocks serializes dues changes per user. it is package level because
+// the services are built per command, while the lock has to be shared by
+// every caller touching the same user.
+var userLocks = lock.NewKeyLocks()
+
+// maxConflictRetries is how many times a write that only sets a value is
+// re-read and retried after losing an optimistic concurrency race
+const maxConflictRetries = 3
+
 type userService struct {
-	dbSrv   model.ModelManager
-	mailSrv email.EmailService
-	l       log.Logger
+	dbSrv     model.ModelManager
+	mailSrv   email.EmailService
+	l         log.Logger
+	ledgerSrv ledger.LedgerService
 }
 
 func NewUserService(db model.ModelManager, email email.EmailService, log log.Logger) UserService {
 	return &userService{
-		db, email, log,
+		db, email, log, ledger.NewLedgerService(db, log),
 	}
 }
 
-func (u userService) ChangeCreditLimit(userName string, limit float64) (*model.User, error) {
+// WithModelManager returns a copy of the service working on db, typically a
+// model.Tx so that its writes join a unit of work
+func (u userService) WithModelManager(db model.ModelManager) UserService {
+	u.dbSrv = db
+	u.ledgerSrv = u.ledgerSrv.WithModelManager(db)
+	return &u
+}
+
+// LockUser blocks until the caller is the only one posting to the
+// receivable of the user, and returns the function releasing it. it is not
+// reentrant.
+func (u userService) LockUser(name string) func() {
+	return userLocks.Lock(name)
+}
+
+func (u userService) ChangeCreditLimit(userName string, limit model.Money) (*model.User, error) {
 
-	if limit < 0 {
+	if limit.IsNegative() {
 		return nil, fmt.Errorf("invalid limit")
 	}
 
+	unlock := userLocks.Lock(userName)
+	defer unlock()
+
+	for attempt := 0; ; attempt++ {
+		nUser, err := u.changeCreditLimit(userName, limit)
+		if model.IsVersionConflict(err) && attempt < maxConflictRetries {
+			u.l.WarnD("retrying credit limit change after conflict", log.Fields{"user": userName, "attempt": attempt})
+			continue
+		}
+		return nUser, err
+	}
+}
+
+func (u userService) changeCreditLimit(userName string, limit model.Money) (*model.User, error) {
+
 	usr := model.User{
 		Name: userName,
 	}
@@ -52,7 +105,7 @@ func (u userService) ChangeCreditLimit(userName string, limit float64) (*model.U
 		return nil, fmt.Errorf("can not able to type assert model")
 	}
 
-	user.CreditLimit = int(limit * 100)
+	user.CreditLimit = limit
 
 	nModel, err := u.dbSrv.Upsert(user)
 	if err != nil {
@@ -68,17 +121,23 @@ func (u userService) ChangeCreditLimit(userName string, limit float64) (*model.U
 	return &nUser, nil
 }
 
-func (u userService) CreateNewUser(name string, mail string, limit float64) (*model.User, error) {
+// CreateNewUser creates a user whose credit limit and dues are in currency,
+// empty for the default one
+func (u userService) CreateNewUser(name string, mail string, limit model.Money, currency model.Currency) (*model.User, error) {
 
 	if !u.mailSrv.IsValid(mail) {
 		return nil, fmt.Errorf("invalid mail")
 	}
 
+	if limit.IsNegative() {
+		return nil, fmt.Errorf("invalid limit")
+	}
+
 	nUser := model.User{
 		Name:        name,
 		Email:       mail,
-		CreditLimit: int(limit * 100),
-		Dues:        0,
+		Currency:    currency.OrDefault(),
+		CreditLimit: limit,
 	}
 
 	_, found, err := u.dbSrv.GetWithPrimaryKey(nUser)
@@ -128,53 +187,120 @@ func (u userService) GetUserWithName(name string) (*model.User, error) {
 	return &nUser, nil
 }
 
-func (u userService) UpdateUserDues(name string, dues int) (*model.User, error) {
-	if dues < 0 {
-		return nil, fmt.Errorf("dues can not be negative")
+// GetUserDues is what the user owes, the balance of their receivable
+func (u userService) GetUserDues(name string) (model.Money, error) {
+
+	user, err := u.GetUserWithName(name)
+	if err != nil {
+		return 0, err
 	}
 
-	user := model.User{
-		Name: name,
+	return u.ledgerSrv.GetUserDues(user.Name, user.Currency)
+}
+
+// GetHeldAmount is the credit of the user reserved by pending authorizations,
+// in the currency of their dues
+func (u userService) GetHeldAmount(name string) (model.Money, error) {
+
+	auths, err := u.dbSrv.Query(model.Authorization{}, model.NewQuery().Where("UserName", model.Eq, name).Where("State", model.Eq, model.AUTHORIZATION_PENDING))
+	if err != nil {
+		return 0, err
 	}
 
-	nModel, found, err := u.dbSrv.GetWithPrimaryKey(user)
+	now := u.dbSrv.Clock().Now()
+
+	var held model.Money
+	for _, m := range auths {
+		auth, ok := m.(model.Authorization)
+		if !ok {
+			return 0, fmt.Errorf("can not able to type assert authorization")
+		}
+
+		if !auth.Holds(now) {
+			continue
+		}
+
+		if held, err = held.Add(auth.Amount); err != nil {
+			return 0, err
+		}
+	}
+
+	return held, nil
+}
+
+// GetAvailableCredit is what the user can still spend, their credit limit
+// less their dues and holds, along with what is held
+func (u userService) GetAvailableCredit(name string) (model.Money, model.Money, error) {
+
+	user, err := u.GetUserWithName(name)
 	if err != nil {
-		u.l.ErrorD("can not able to get model with primary key", log.Fields{"primary Key": user.Name})
-		return nil, err
+		return 0, 0, err
 	}
 
-	if !found {
-		return nil, fmt.Errorf("user can not be found")
+	dues, err := u.ledgerSrv.GetUserDues(user.Name, user.Currency)
+	if err != nil {
+		return 0, 0, err
 	}
 
-	nUser, ok := nModel.(model.User)
-	if !ok {
-		return nil, fmt.Errorf("can not able to type asssert user")
+	held, err := u.GetHeldAmount(user.Name)
+	if err != nil {
+		return 0, 0, err
 	}
 
-	if dues > nUser.CreditLimit {
-		return nil, fmt.Errorf("due is over credit limit. credit limit: %d", nUser.CreditLimit)
+	available, err := user.CreditLimit.Sub(dues)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if available, err = available.Sub(held); err != nil {
+		return 0, 0, err
 	}
 
-	nUser.Dues = dues
+	return available, held, nil
+}
+
+// DeleteUser soft deletes a user without dues, keeping the name reserved
+// since the ledger still refers to it
+func (u userService) DeleteUser(name string) error {
 
-	newModel, err := u.dbSrv.Upsert(nUser)
+	unlock := userLocks.Lock(name)
+	defer unlock()
+
+	user, err := u.GetUserWithName(name)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	newUser, ok := newModel.(model.User)
-	if !ok {
-		return nil, fmt.Errorf("can not able to type assert")
+	dues, err := u.ledgerSrv.GetUserDues(user.Name, user.Currency)
+	if err != nil {
+		return err
 	}
 
-	return &newUser, nil
+	if dues != 0 {
+		return fmt.Errorf("user has pending dues")
+	}
+
+	held, err := u.GetHeldAmount(user.Name)
+	if err != nil {
+		return err
+	}
+
+	if held != 0 {
+		return fmt.Errorf("user has pending authorizations")
+	}
+
+	if err := u.dbSrv.Delete(*user); err != nil {
+		u.l.ErrorD("can not able to delete user", log.Fields{"user": user.Name})
+		return err
+	}
+
+	return nil
 }
 
 func (u userService) GetCreditLimitUsers() ([]*model.User, error) {
 	var resp = make([]*model.User, 0)
 
-	users, err := u.dbSrv.GetAll(model.User{})
+	users, err := u.dbSrv.Query(model.User{}, model.NewQuery().OrderBy("Name", false))
 	if err != nil {
 		return resp, err
 	}
@@ -185,7 +311,22 @@ func (u userService) GetCreditLimitUsers() ([]*model.User, error) {
 			return resp, fmt.Errorf("can not able to type assert")
 		}
 
-		if nuser.Dues >= nuser.CreditLimit {
+		dues, err := u.ledgerSrv.GetUserDues(nuser.Name, nuser.Currency)
+		if err != nil {
+			return resp, err
+		}
+
+		//pending authorizations use up the limit as much as dues do
+		held, err := u.GetHeldAmount(nuser.Name)
+		if err != nil {
+			return resp, err
+		}
+
+		if dues, err = dues.Add(held); err != nil {
+			return resp, err
+		}
+
+		if dues >= nuser.CreditLimit {
 			resp = append(resp, &nuser)
 		}
 	}
@@ -193,10 +334,10 @@ func (u userService) GetCreditLimitUsers() ([]*model.User, error) {
 	return resp, nil
 }
 
-func (u userService) GetTotalDues() ([]*model.User, error) {
-	var resp = make([]*model.User, 0)
+func (u userService) GetTotalDues() ([]UserDues, error) {
+	var resp = make([]UserDues, 0)
 
-	users, err := u.dbSrv.GetAll(model.User{})
+	users, err := u.dbSrv.Query(model.User{}, model.NewQuery().OrderBy("Name", false))
 	if err != nil {
 		return resp, err
 	}
@@ -207,7 +348,12 @@ func (u userService) GetTotalDues() ([]*model.User, error) {
 			return resp, fmt.Errorf("can not able to type assert")
 		}
 
-		resp = append(resp, &nuser)
+		dues, err := u.ledgerSrv.GetUserDues(nuser.Name, nuser.Currency)
+		if err != nil {
+			return resp, err
+		}
+
+		resp = append(resp, UserDues{User: nuser, Dues: dues})
 	}
 
 	return resp, nil
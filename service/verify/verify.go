@@ -0,0 +1,910 @@
+package verify
+
+import (
+	"bytes"
+	"fmt"
+	"pay-later/integration/log"
+	"pay-later/model"
+	"pay-later/service/ledger"
+	"pay-later/service/transaction"
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+type MismatchKind string
+
+const (
+	DUES_MISMATCH        = MismatchKind("dues")        //receivable of a user differs from its transfers
+	DISCOUNT_MISMATCH    = MismatchKind("discount")    //discount of a merchant differs from its transfers
+	POSTING_MISMATCH     = MismatchKind("posting")     //journal lines of a transfer differ from the transfer
+	TRANSACTION_MISMATCH = MismatchKind("transaction") //transaction rows of a transfer differ from the transfer
+	ORPHAN_POSTING       = MismatchKind("orphan")      //journal lines of no known transfer
+)
+
+// Mismatch is one disagreement found by VerifyLedger. Name is the user,
+// merchant or account it is about, and Repaired tells whether the repair run
+// fixed it.
+type Mismatch struct {
+	Kind        MismatchKind
+	Name        string
+	Currency    model.Currency
+	Expected    model.Money
+	Actual      model.Money
+	TransferIDs []uuid.UUID
+	Repaired    bool
+}
+
+type Report struct {
+	Users      int
+	Merchants  int
+	Transfers  int
+	Mismatches []Mismatch
+}
+
+// OK reports whether everything matched, or was repaired
+func (r Report) OK() bool {
+
+	for _, m := range r.Mismatches {
+		if !m.Repaired {
+			return false
+		}
+	}
+
+	return true
+}
+
+type VerifyService interface {
+	VerifyLedger(repair bool) (*Report, error)
+}
+
+type verifyService struct {
+	l          log.Logger
+	txnService transaction.TransactionService
+	ledgerSrv  ledger.LedgerService
+	dbSrv      model.ModelManager
+}
+
+func NewVerifyService(l log.Logger, txnSrv transaction.TransactionService, ledgerSrv ledger.LedgerService, dbSrv model.ModelManager) VerifyService {
+	return &verifyService{
+		l, txnSrv, ledgerSrv, dbSrv,
+	}
+}
+
+// postings are the net amounts, debit positive, a transfer moves per account
+type postings map[string]model.Money
+
+func (p postings) add(account model.Account, amount model.Money) error {
+
+	v, err := p[account.ID()].Add(amount)
+	if err != nil {
+		return err
+	}
+
+	p[account.ID()] = v
+
+	return nil
+}
+
+func (p postings) sub(account model.Account, amount model.Money) error {
+
+	v, err := p[account.ID()].Sub(amount)
+	if err != nil {
+		return err
+	}
+
+	p[account.ID()] = v
+
+	return nil
+}
+
+// interTransferPostings are what CreateInterTransfer posts for t
+func interTransferPostings(t model.InterTransfer) (postings, error) {
+
+	userCurrency, merchantCurrency := t.Currency.OrDefault(), t.MerchantCurrency.OrDefault()
+	sale := t.SaleAmount()
+
+	net, err := sale.Sub(t.DiscountAmount)
+	if err != nil {
+		return nil, err
+	}
+
+	p := make(postings)
+
+	for _, err := range []error{
+		p.add(model.UserReceivable(t.UserName, userCurrency), t.Amount),
+		p.sub(model.ClearingAccount(userCurrency), t.Amount),
+		p.add(model.ClearingAccount(merchantCurrency), sale),
+		p.sub(model.MerchantPayable(t.MerchantName, merchantCurrency), net),
+		p.sub(model.LenderRevenue(merchantCurrency), t.DiscountAmount),
+	} {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// refundPostings are what RefundInterTransfer posts for t, the postings of
+// a purchase backwards
+func refundPostings(t model.RefundTransfer) (postings, error) {
+
+	userCurrency, merchantCurrency := t.Currency.OrDefault(), t.MerchantCurrency.OrDefault()
+
+	net, err := t.MerchantAmount.Sub(t.DiscountAmount)
+	if err != nil {
+		return nil, err
+	}
+
+	p := make(postings)
+
+	for _, err := range []error{
+		p.sub(model.UserReceivable(t.UserName, userCurrency), t.Amount),
+		p.add(model.ClearingAccount(userCurrency), t.Amount),
+		p.sub(model.ClearingAccount(merchantCurrency), t.MerchantAmount),
+		p.add(model.MerchantPayable(t.MerchantName, merchantCurrency), net),
+		p.add(model.LenderRevenue(merchantCurrency), t.DiscountAmount),
+	} {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// disputePostings are what a dispute posts for d, its provisional credit
+// and, once resolved, the reversal of it. the chargeback of a dispute
+// resolved in favour of the user is a refund, verified as one.
+func disputePostings(d model.Dispute) (postings, error) {
+
+	currency := d.Currency.OrDefault()
+
+	credit := d.ProvisionalCredit
+	if d.IsResolved() {
+		credit = 0
+	}
+
+	p := make(postings)
+
+	if err := p.add(model.DisputeSuspense(currency), credit); err != nil {
+		return nil, err
+	}
+
+	if err := p.sub(model.UserReceivable(d.UserName, currency), credit); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// paybackPostings are what CreatePaybackTransfer posts for t
+func paybackPostings(t model.UserPaybackTransfer) (postings, error) {
+
+	currency := t.Currency.OrDefault()
+
+	p := make(postings)
+
+	if err := p.add(model.ExternalAccount(currency), t.Amount); err != nil {
+		return nil, err
+	}
+
+	if err := p.sub(model.UserReceivable(t.UserName, currency), t.Amount); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// userCharge is a transfer debiting the receivable of a user against one
+// other account, with the single transaction row it writes
+type userCharge struct {
+	id       uuid.UUID
+	txnType  model.TransactionType
+	userName string
+	amount   model.Money
+	currency model.Currency
+	against  func(model.Currency) model.Account
+}
+
+// postings are what a late fee, interest or credit refund posts
+func (c userCharge) postings() (postings, error) {
+
+	currency := c.currency.OrDefault()
+
+	p := make(postings)
+
+	if err := p.add(model.UserReceivable(c.userName, currency), c.amount); err != nil {
+		return nil, err
+	}
+
+	if err := p.sub(c.against(currency), c.amount); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// VerifyLedger recomputes what every transfer should have posted, and so the
+// dues of every user and the discount of every merchant, from the transfer
+// tables, and compares it with the journal, the transaction rows and the
+// merchant totals. with repair, journal lines that differ from their transfer
+// are corrected with ledger-adjustment entries, which also posts transfers
+// made before the journal existed, and merchant totals are rebuilt. rows of
+// the append-only transfer and transaction tables are never changed, so
+// mismatches between them are only reported.
+// callers make sure no transfer is posted meanwhile.
+func (v verifyService) VerifyLedger(repair bool) (*Report, error) {
+
+	report := &Report{}
+
+	expected := make(map[uuid.UUID]postings)
+	actual := make(map[uuid.UUID]postings)
+
+	transfers, err := v.dbSrv.GetAll(model.InterTransfer{})
+	if err != nil {
+		return nil, err
+	}
+
+	var interTransfers = make([]model.InterTransfer, 0, len(transfers))
+	for _, m := range transfers {
+		t, ok := m.(model.InterTransfer)
+		if !ok {
+			return nil, fmt.Errorf("can not able to type assert transfer")
+		}
+
+		if expected[t.ID], err = interTransferPostings(t); err != nil {
+			return nil, err
+		}
+		interTransfers = append(interTransfers, t)
+	}
+
+	paybacks, err := v.dbSrv.GetAll(model.UserPaybackTransfer{})
+	if err != nil {
+		return nil, err
+	}
+
+	var paybackTransfers = make([]model.UserPaybackTransfer, 0, len(paybacks))
+	for _, m := range paybacks {
+		t, ok := m.(model.UserPaybackTransfer)
+		if !ok {
+			return nil, fmt.Errorf("can not able to type assert transfer")
+		}
+
+		if expected[t.ID], err = paybackPostings(t); err != nil {
+			return nil, err
+		}
+		paybackTransfers = append(paybackTransfers, t)
+	}
+
+	refunds, err := v.dbSrv.GetAll(model.RefundTransfer{})
+	if err != nil {
+		return nil, err
+	}
+
+	var refundTransfers = make([]model.RefundTransfer, 0, len(refunds))
+	for _, m := range refunds {
+		t, ok := m.(model.RefundTransfer)
+		if !ok {
+			return nil, fmt.Errorf("can not able to type assert transfer")
+		}
+
+		if expected[t.ID], err = refundPostings(t); err != nil {
+			return nil, err
+		}
+		refundTransfers = append(refundTransfers, t)
+	}
+
+	disputes, err := v.dbSrv.GetAll(model.Dispute{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range disputes {
+		d, ok := m.(model.Dispute)
+		if !ok {
+			return nil, fmt.Errorf("can not able to type assert dispute")
+		}
+
+		if expected[d.ID], err = disputePostings(d); err != nil {
+			return nil, err
+		}
+	}
+
+	charges, err := v.userCharges()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range charges {
+		if expected[c.id], err = c.postings(); err != nil {
+			return nil, err
+		}
+	}
+
+	report.Transfers = len(expected)
+
+	lines, err := v.dbSrv.GetAll(model.JournalLine{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range lines {
+		line, ok := m.(model.JournalLine)
+		if !ok {
+			return nil, fmt.Errorf("can not able to type assert journal line")
+		}
+
+		p, ok := actual[line.TransferID]
+		if !ok {
+			p = make(postings)
+			actual[line.TransferID] = p
+		}
+
+		net, err := line.Debit.Sub(line.Credit)
+		if err != nil {
+			return nil, err
+		}
+
+		if p[line.AccountID], err = p[line.AccountID].Add(net); err != nil {
+			return nil, err
+		}
+	}
+
+	//transfers whose posting to an account differs, by account
+	var wrong = make(map[string][]uuid.UUID)
+	var adjustments = make(map[uuid.UUID]postings)
+
+	for _, id := range sortedIDs(expected, actual) {
+		exp, known := expected[id]
+		first := len(report.Mismatches)
+
+		for _, accountID := range sortedAccounts(exp, actual[id]) {
+			e, a := exp[accountID], actual[id][accountID]
+			if e == a {
+				continue
+			}
+
+			account, err := model.ParseAccountID(accountID)
+			if err != nil {
+				return nil, err
+			}
+
+			mismatch := Mismatch{
+				Kind:        POSTING_MISMATCH,
+				Name:        accountID,
+				Currency:    account.Currency,
+				Expected:    e,
+				Actual:      a,
+				TransferIDs: []uuid.UUID{id},
+			}
+
+			if !known {
+				mismatch.Kind = ORPHAN_POSTING
+			} else {
+				delta, err := e.Sub(a)
+				if err != nil {
+					return nil, err
+				}
+
+				if adjustments[id] == nil {
+					adjustments[id] = make(postings)
+				}
+				adjustments[id][accountID] = delta
+				mismatch.Repaired = repair
+			}
+
+			wrong[accountID] = append(wrong[accountID], id)
+			report.Mismatches = append(report.Mismatches, mismatch)
+		}
+
+		//lines that do not balance can not be corrected by balanced entries
+		if adjustments[id] != nil {
+			ok, err := balanced(adjustments[id])
+			if err != nil {
+				return nil, err
+			}
+
+			if !ok {
+				delete(adjustments, id)
+				for i := first; i < len(report.Mismatches); i++ {
+					report.Mismatches[i].Repaired = false
+				}
+			}
+		}
+	}
+
+	duesMismatches, users, err := v.verifyDues(expected, adjustments, wrong, repair)
+	if err != nil {
+		return nil, err
+	}
+	report.Mismatches = append(report.Mismatches, duesMismatches...)
+	report.Users = users
+
+	discountMismatches, merchants, rebuild, err := v.verifyDiscounts(interTransfers, refundTransfers, paybackTransfers, charges, repair)
+	if err != nil {
+		return nil, err
+	}
+	report.Mismatches = append(report.Mismatches, discountMismatches...)
+	report.Merchants = merchants
+
+	if repair {
+		if err := v.postAdjustments(adjustments); err != nil {
+			return nil, err
+		}
+
+		if rebuild {
+			if _, err := v.txnService.RebuildMerchantTotals(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// userCharges are the late fees, interest and credit refunds of users
+func (v verifyService) userCharges() ([]userCharge, error) {
+
+	var resp []userCharge
+
+	fees, err := v.dbSrv.GetAll(model.LateFeeTransfer{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range fees {
+		t, ok := m.(model.LateFeeTransfer)
+		if !ok {
+			return nil, fmt.Errorf("can not able to type assert transfer")
+		}
+		resp = append(resp, userCharge{t.ID, model.LATE_FEE, t.UserName, t.Amount, t.Currency, model.LenderRevenue})
+	}
+
+	interest, err := v.dbSrv.GetAll(model.InterestTransfer{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range interest {
+		t, ok := m.(model.InterestTransfer)
+		if !ok {
+			return nil, fmt.Errorf("can not able to type assert transfer")
+		}
+		resp = append(resp, userCharge{t.ID, model.INTEREST, t.UserName, t.Amount, t.Currency, model.LenderRevenue})
+	}
+
+	refunds, err := v.dbSrv.GetAll(model.CreditRefundTransfer{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range refunds {
+		t, ok := m.(model.CreditRefundTransfer)
+		if !ok {
+			return nil, fmt.Errorf("can not able to type assert transfer")
+		}
+		resp = append(resp, userCharge{t.ID, model.CREDIT_REFUND, t.UserName, t.Amount, t.Currency, model.ExternalAccount})
+	}
+
+	return resp, nil
+}
+
+// verifyDues compares the receivable of every user with what their transfers
+// add up to. a mismatch is repaired when every transfer behind it is adjusted.
+func (v verifyService) verifyDues(expected map[uuid.UUID]postings, adjustments map[uuid.UUID]postings, wrong map[string][]uuid.UUID, repair bool) ([]Mismatch, int, error) {
+
+	var resp []Mismatch
+
+	totals := make(postings)
+	for _, p := range expected {
+		for accountID, amount := range p {
+			sum, err := totals[accountID].Add(amount)
+			if err != nil {
+				return nil, 0, err
+			}
+			totals[accountID] = sum
+		}
+	}
+
+	users, err := v.dbSrv.Query(model.User{}, model.NewQuery().WithDeleted())
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, m := range users {
+		user, ok := m.(model.User)
+		if !ok {
+			return nil, 0, fmt.Errorf("can not able to type assert user")
+		}
+
+		account := model.UserReceivable(user.Name, user.Currency)
+		dues := totals[account.ID()]
+
+		balance, err := v.ledgerSrv.GetBalance(account)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		posted, err := balance.Net()
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if dues == posted {
+			continue
+		}
+
+		mismatch := Mismatch{
+			Kind:        DUES_MISMATCH,
+			Name:        user.Name,
+			Currency:    account.Currency,
+			Expected:    dues,
+			Actual:      posted,
+			TransferIDs: wrong[account.ID()],
+			Repaired:    repair,
+		}
+
+		for _, id := range mismatch.TransferIDs {
+			if _, adjusted := adjustments[id]; !adjusted {
+				mismatch.Repaired = false
+			}
+		}
+
+		resp = append(resp, mismatch)
+	}
+
+	return resp, len(users), nil
+}
+
+// verifyDiscounts recomputes the discount of every transfer at its rate and
+// rounding policy, compares the transaction rows with the transfers and the
+// merchant totals with the transfers, and tells whether rebuilding the totals
+// from the transaction rows repairs them.
+func (v verifyService) verifyDiscounts(interTransfers []model.InterTransfer, refundTransfers []model.RefundTransfer, paybackTransfers []model.UserPaybackTransfer, charges []userCharge, repair bool) ([]Mismatch, int, bool, error) {
+
+	var resp []Mismatch
+
+	rows, err := v.dbSrv.GetAll(model.Transaction{})
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	//transaction amounts by transfer and type
+	var txns = make(map[uuid.UUID]map[model.TransactionType]model.Money)
+	for _, m := range rows {
+		txn, ok := m.(model.Transaction)
+		if !ok {
+			return nil, 0, false, fmt.Errorf("can not able to type assert transaction")
+		}
+
+		if txns[txn.TransferID] == nil {
+			txns[txn.TransferID] = make(map[model.TransactionType]model.Money)
+		}
+
+		amount, err := txns[txn.TransferID][txn.Type].Add(txn.Amount)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		txns[txn.TransferID][txn.Type] = amount
+	}
+
+	type merchantDiscount struct {
+		currency    model.Currency
+		expected    model.Money
+		transferIDs []uuid.UUID //whose transaction rows disagree with them
+	}
+
+	var merchants = make(map[string]*merchantDiscount)
+
+	for _, t := range interTransfers {
+		currency := t.MerchantCurrency.OrDefault()
+
+		d, ok := merchants[t.MerchantName]
+		if !ok {
+			d = &merchantDiscount{currency: currency}
+			merchants[t.MerchantName] = d
+		}
+
+		if d.expected, err = d.expected.Add(t.DiscountAmount); err != nil {
+			return nil, 0, false, err
+		}
+
+		//transfers before rounding policies were recorded have no rate to recompute with
+		if t.Rounding != "" {
+			discount, _, err := t.Rounding.ApplyRate(t.SaleAmount(), t.DiscountRate)
+			if err != nil {
+				return nil, 0, false, err
+			}
+
+			if discount != t.DiscountAmount {
+				resp = append(resp, Mismatch{
+					Kind:        DISCOUNT_MISMATCH,
+					Name:        t.MerchantName,
+					Currency:    currency,
+					Expected:    discount,
+					Actual:      t.DiscountAmount,
+					TransferIDs: []uuid.UUID{t.ID},
+				})
+			}
+		}
+
+		net, err := t.SaleAmount().Sub(t.DiscountAmount)
+		if err != nil {
+			return nil, 0, false, err
+		}
+
+		if txns[t.ID][model.MERCHANT_DISCOUNT_CREDIT] != t.DiscountAmount {
+			resp = append(resp, Mismatch{
+				Kind:        TRANSACTION_MISMATCH,
+				Name:        string(model.MERCHANT_DISCOUNT_CREDIT),
+				Currency:    currency,
+				Expected:    t.DiscountAmount,
+				Actual:      txns[t.ID][model.MERCHANT_DISCOUNT_CREDIT],
+				TransferIDs: []uuid.UUID{t.ID},
+			})
+			d.transferIDs = append(d.transferIDs, t.ID)
+		}
+
+		if txns[t.ID][model.USER_MERCHANT_TRANSFER] != net {
+			resp = append(resp, Mismatch{
+				Kind:        TRANSACTION_MISMATCH,
+				Name:        string(model.USER_MERCHANT_TRANSFER),
+				Currency:    currency,
+				Expected:    net,
+				Actual:      txns[t.ID][model.USER_MERCHANT_TRANSFER],
+				TransferIDs: []uuid.UUID{t.ID},
+			})
+		}
+	}
+
+	//the compensating rows of refunds are written against the transfer they
+	//reverse, so they are compared with every refund of it together
+	type refunded struct {
+		currency model.Currency
+		net      model.Money
+		discount model.Money
+	}
+
+	var refundedTransfers = make(map[uuid.UUID]*refunded)
+	var refundOrder []uuid.UUID
+
+	for _, t := range refundTransfers {
+		currency := t.MerchantCurrency.OrDefault()
+
+		if d, ok := merchants[t.MerchantName]; ok {
+			if d.expected, err = d.expected.Sub(t.DiscountAmount); err != nil {
+				return nil, 0, false, err
+			}
+		}
+
+		r, ok := refundedTransfers[t.TransferID]
+		if !ok {
+			r = &refunded{currency: currency}
+			refundedTransfers[t.TransferID] = r
+			refundOrder = append(refundOrder, t.TransferID)
+		}
+
+		net, err := t.MerchantAmount.Sub(t.DiscountAmount)
+		if err != nil {
+			return nil, 0, false, err
+		}
+
+		if r.net, err = r.net.Add(net); err != nil {
+			return nil, 0, false, err
+		}
+
+		if r.discount, err = r.discount.Add(t.DiscountAmount); err != nil {
+			return nil, 0, false, err
+		}
+	}
+
+	for _, id := range refundOrder {
+		r := refundedTransfers[id]
+
+		for _, row := range []struct {
+			txnType model.TransactionType
+			amount  model.Money
+		}{{model.MERCHANT_REFUND, r.net}, {model.DISCOUNT_CLAWBACK, r.discount}} {
+			txnType, amount := row.txnType, row.amount
+			if txns[id][txnType] != amount {
+				resp = append(resp, Mismatch{
+					Kind:        TRANSACTION_MISMATCH,
+					Name:        string(txnType),
+					Currency:    r.currency,
+					Expected:    amount,
+					Actual:      txns[id][txnType],
+					TransferIDs: []uuid.UUID{id},
+				})
+			}
+		}
+	}
+
+	for _, t := range paybackTransfers {
+		if txns[t.ID][model.USER_PAYBACK_TRANSFER] != t.Amount {
+			resp = append(resp, Mismatch{
+				Kind:        TRANSACTION_MISMATCH,
+				Name:        string(model.USER_PAYBACK_TRANSFER),
+				Currency:    t.Currency.OrDefault(),
+				Expected:    t.Amount,
+				Actual:      txns[t.ID][model.USER_PAYBACK_TRANSFER],
+				TransferIDs: []uuid.UUID{t.ID},
+			})
+		}
+	}
+
+	for _, c := range charges {
+		if txns[c.id][c.txnType] != c.amount {
+			resp = append(resp, Mismatch{
+				Kind:        TRANSACTION_MISMATCH,
+				Name:        string(c.txnType),
+				Currency:    c.currency.OrDefault(),
+				Expected:    c.amount,
+				Actual:      txns[c.id][c.txnType],
+				TransferIDs: []uuid.UUID{c.id},
+			})
+		}
+	}
+
+	var names = make([]string, 0, len(merchants))
+	for name := range merchants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var rebuild bool
+
+	for _, name := range names {
+		d := merchants[name]
+
+		totals, err := v.txnService.GetMerchantTotals(name)
+		if err != nil {
+			return nil, 0, false, err
+		}
+
+		if totals.DiscountEarned == d.expected {
+			continue
+		}
+
+		//the totals are rebuilt from the transaction rows, which only helps
+		//when those agree with the transfers
+		rebuild = true
+		resp = append(resp, Mismatch{
+			Kind:        DISCOUNT_MISMATCH,
+			Name:        name,
+			Currency:    d.currency,
+			Expected:    d.expected,
+			Actual:      totals.DiscountEarned,
+			TransferIDs: d.transferIDs,
+			Repaired:    repair && len(d.transferIDs) == 0,
+		})
+	}
+
+	return resp, len(merchants), rebuild, nil
+}
+
+// postAdjustments posts a ledger-adjustment entry per transfer and currency
+// bringing its journal lines to what the transfer posts, all or none
+func (v verifyService) postAdjustments(adjustments map[uuid.UUID]postings) error {
+
+	if len(adjustments) == 0 {
+		return nil
+	}
+
+	tx, err := v.dbSrv.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ledgerSrv := v.ledgerSrv.WithModelManager(tx)
+
+	for _, id := range sortedIDs(adjustments, nil) {
+
+		var byCurrency = make(map[model.Currency][]model.JournalLine)
+		var currencies []model.Currency
+
+		for _, accountID := range sortedAccounts(adjustments[id], nil) {
+			account, err := model.ParseAccountID(accountID)
+			if err != nil {
+				return err
+			}
+
+			delta := adjustments[id][accountID]
+
+			line := model.Debit(account, delta)
+			if delta.IsNegative() {
+				credit, err := model.Money(0).Sub(delta)
+				if err != nil {
+					return err
+				}
+				line = model.Credit(account, credit)
+			}
+
+			if _, ok := byCurrency[account.Currency]; !ok {
+				currencies = append(currencies, account.Currency)
+			}
+			byCurrency[account.Currency] = append(byCurrency[account.Currency], line)
+		}
+
+		for _, currency := range currencies {
+			entry := model.JournalEntry{TransferID: id, Type: model.LEDGER_ADJUSTMENT, Currency: currency}
+
+			if _, err := ledgerSrv.PostEntry(entry, byCurrency[currency]...); err != nil {
+				v.l.ErrorD("can not able to post ledger adjustment", log.Fields{"transfer": id, "lines": byCurrency[currency]})
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// balanced tells whether an adjustment nets to zero in every currency, so
+// that it can be posted as journal entries
+func balanced(p postings) (bool, error) {
+
+	var totals = make(map[model.Currency]model.Money)
+
+	for accountID, amount := range p {
+		account, err := model.ParseAccountID(accountID)
+		if err != nil {
+			return false, err
+		}
+
+		if totals[account.Currency], err = totals[account.Currency].Add(amount); err != nil {
+			return false, err
+		}
+	}
+
+	for _, total := range totals {
+		if total != 0 {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// sortedIDs are the transfer ids of both maps in a stable order
+func sortedIDs(a map[uuid.UUID]postings, b map[uuid.UUID]postings) []uuid.UUID {
+
+	var seen = make(map[uuid.UUID]bool)
+	var resp []uuid.UUID
+
+	for _, m := range []map[uuid.UUID]postings{a, b} {
+		for id := range m {
+			if !seen[id] {
+				seen[id] = true
+				resp = append(resp, id)
+			}
+		}
+	}
+
+	sort.Slice(resp, func(i, j int) bool {
+		return bytes.Compare(resp[i][:], resp[j][:]) < 0
+	})
+
+	return resp
+}
+
+// sortedAccounts are the account ids of both postings in order
+func sortedAccounts(a postings, b postings) []string {
+
+	var seen = make(map[string]bool)
+	var resp []string
+
+	for _, p := range []postings{a, b} {
+		for id := range p {
+			if !seen[id] {
+				seen[id] = true
+				resp = append(resp, id)
+			}
+		}
+	}
+
+	sort.Strings(resp)
+
+	return resp
+}
@@ -0,0 +1,384 @@
+package verify
+
+import (
+	"io/ioutil"
+	"pay-later/integration/email"
+	"pay-later/integration/log"
+	"pay-later/model"
+	"pay-later/service/fx"
+	"pay-later/service/ledger"
+	"pay-later/service/merchant"
+	"pay-later/service/transaction"
+	"pay-later/service/transfer"
+	"pay-later/service/user"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+type testServices struct {
+	db        model.ModelManager
+	users     user.UserService
+	txns      transaction.TransactionService
+	transfers transfer.TransferService
+	verify    VerifyService
+}
+
+// newTestBook wires the services over an in-memory model manager, and books
+// two sales of u1 at m1, returned, a refund of part of the first and a payback
+func newTestBook(t *testing.T) (testServices, []*model.InterTransfer) {
+	t.Helper()
+
+	l := log.NewLogger(log.SetOutput(ioutil.Discard))
+
+	db, err := model.NewModelManager(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	emailSrv := email.NewEmailService(l)
+	txnSrv := transaction.NewTransactionService(db, l)
+	usrSrv := user.NewUserService(db, emailSrv, l)
+	mrtSrv := merchant.NewMerchantService(db, emailSrv, l)
+	ledgerSrv := ledger.NewLedgerService(db, l)
+
+	s := testServices{
+		db:        db,
+		users:     usrSrv,
+		txns:      txnSrv,
+		transfers: transfer.NewTransferService(l, txnSrv, usrSrv, mrtSrv, fx.NewFXService(l, db), ledgerSrv, db),
+		verify:    NewVerifyService(l, txnSrv, ledgerSrv, db),
+	}
+
+	if _, err := usrSrv.CreateNewUser("u1", "u1@users.com", 100000, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := mrtSrv.CreateNewMerchant("m1", "m1@merchants.com", 150, "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := s.transfers.CreateInterTransfer("u1", "m1", 10001)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := s.transfers.CreateInterTransfer("u1", "m1", 2500)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.transfers.RefundInterTransfer(first.ID, 3333); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.transfers.CreatePaybackTransfer("u1", 5000); err != nil {
+		t.Fatal(err)
+	}
+
+	return s, []*model.InterTransfer{first, second}
+}
+
+func mustVerify(t *testing.T, s testServices, repair bool) *Report {
+	t.Helper()
+
+	report, err := s.verify.VerifyLedger(repair)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return report
+}
+
+// assertClean checks that nothing was found, not even something repaired
+func assertClean(t *testing.T, report *Report) {
+	t.Helper()
+
+	if !report.OK() || len(report.Mismatches) != 0 {
+		t.Errorf("got mismatches %+v, want none", report.Mismatches)
+	}
+}
+
+func mustPostLine(t *testing.T, s testServices, line model.JournalLine) {
+	t.Helper()
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	line.ID, line.EntryID = id, id
+
+	if _, err := s.db.Upsert(line); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func adjustments(t *testing.T, s testServices) int {
+	t.Helper()
+
+	entries, err := s.db.Query(model.JournalEntry{}, model.NewQuery().Where("Type", model.Eq, model.LEDGER_ADJUSTMENT))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return len(entries)
+}
+
+func TestVerifyCleanBook(t *testing.T) {
+
+	s, _ := newTestBook(t)
+
+	report := mustVerify(t, s, false)
+	assertClean(t, report)
+
+	if report.Users != 1 || report.Merchants != 1 || report.Transfers != 4 {
+		t.Errorf("verified %d users, %d merchants and %d transfers, want 1, 1 and 4", report.Users, report.Merchants, report.Transfers)
+	}
+
+	assertClean(t, mustVerify(t, s, true))
+
+	if n := adjustments(t, s); n != 0 {
+		t.Errorf("repair of a clean book posted %d adjustments", n)
+	}
+}
+
+func TestVerifyReportsUnbalancedPosting(t *testing.T) {
+
+	s, sales := newTestBook(t)
+	first := sales[0]
+
+	receivable := model.UserReceivable("u1", model.DefaultCurrency)
+
+	line := model.Debit(receivable, 700)
+	line.TransferID = first.ID
+	mustPostLine(t, s, line)
+
+	report := mustVerify(t, s, false)
+	if report.OK() {
+		t.Fatal("unbalanced posting not reported")
+	}
+
+	var posting, dues bool
+	for _, m := range report.Mismatches {
+		if m.Repaired {
+			t.Errorf("%s mismatch repaired without repair", m.Kind)
+		}
+
+		if len(m.TransferIDs) != 1 || m.TransferIDs[0] != first.ID {
+			t.Errorf("%s mismatch blames %v, want %s", m.Kind, m.TransferIDs, first.ID)
+		}
+
+		switch m.Kind {
+		case POSTING_MISMATCH:
+			posting = m.Name == receivable.ID() && m.Actual-m.Expected == 700
+		case DUES_MISMATCH:
+			dues = m.Name == "u1" && m.Actual-m.Expected == 700
+		default:
+			t.Errorf("unexpected %s mismatch %+v", m.Kind, m)
+		}
+	}
+
+	if !posting || !dues {
+		t.Errorf("got mismatches %+v, want the receivable posting and the dues of u1 off by 7.00", report.Mismatches)
+	}
+}
+
+func TestVerifyReportsMissingPosting(t *testing.T) {
+
+	s, _ := newTestBook(t)
+
+	//a transfer whose journal entries were never posted
+	id, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	missing := model.UserPaybackTransfer{ID: id, UserName: "u1", Amount: 1000, Currency: model.DefaultCurrency}
+	if _, err := s.db.Upsert(missing); err != nil {
+		t.Fatal(err)
+	}
+
+	report := mustVerify(t, s, false)
+	if report.OK() {
+		t.Fatal("missing posting not reported")
+	}
+
+	var accounts = make(map[string]bool)
+	for _, m := range report.Mismatches {
+		if len(m.TransferIDs) != 1 || m.TransferIDs[0] != id {
+			t.Errorf("%s mismatch blames %v, want %s", m.Kind, m.TransferIDs, id)
+		}
+
+		if m.Kind == POSTING_MISMATCH && m.Actual == 0 {
+			accounts[m.Name] = true
+		}
+	}
+
+	for _, account := range []model.Account{model.ExternalAccount(model.DefaultCurrency), model.UserReceivable("u1", model.DefaultCurrency)} {
+		if !accounts[account.ID()] {
+			t.Errorf("no missing posting reported on %s, got %+v", account.ID(), report.Mismatches)
+		}
+	}
+}
+
+func TestVerifyReportsOrphanPosting(t *testing.T) {
+
+	s, _ := newTestBook(t)
+
+	orphan, err := uuid.NewRandom()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	line := model.Debit(model.LenderRevenue(model.DefaultCurrency), 100)
+	line.TransferID = orphan
+	mustPostLine(t, s, line)
+
+	report := mustVerify(t, s, true)
+
+	if len(report.Mismatches) != 1 {
+		t.Fatalf("got mismatches %+v, want the orphan posting", report.Mismatches)
+	}
+
+	if m := report.Mismatches[0]; m.Kind != ORPHAN_POSTING || m.Repaired || m.TransferIDs[0] != orphan {
+		t.Errorf("got %+v, want an unrepaired orphan posting of %s", m, orphan)
+	}
+
+	if n := adjustments(t, s); n != 0 {
+		t.Errorf("repair posted %d adjustments for a posting of no transfer", n)
+	}
+}
+
+func TestRepairBalancesTheBooksOnce(t *testing.T) {
+
+	s, sales := newTestBook(t)
+	first := sales[0]
+
+	before, err := s.users.GetUserDues("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//7.00 moved from the revenue of the lender onto the dues of the user
+	receivable := model.Debit(model.UserReceivable("u1", model.DefaultCurrency), 700)
+	receivable.TransferID = first.ID
+	mustPostLine(t, s, receivable)
+
+	revenue := model.Credit(model.LenderRevenue(model.DefaultCurrency), 700)
+	revenue.TransferID = first.ID
+	mustPostLine(t, s, revenue)
+
+	report := mustVerify(t, s, true)
+	if !report.OK() || len(report.Mismatches) != 3 {
+		t.Fatalf("got mismatches %+v, want the two postings and the dues repaired", report.Mismatches)
+	}
+
+	if n := adjustments(t, s); n != 1 {
+		t.Errorf("repair posted %d adjustments, want 1", n)
+	}
+
+	after, err := s.users.GetUserDues("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if after != before {
+		t.Errorf("dues %s after repair, want %s", after, before)
+	}
+
+	assertClean(t, mustVerify(t, s, false))
+
+	//a second run has nothing left to repair
+	assertClean(t, mustVerify(t, s, true))
+
+	if n := adjustments(t, s); n != 1 {
+		t.Errorf("second repair left %d adjustments, want 1", n)
+	}
+}
+
+func TestRepairLeavesUnbalancedPostings(t *testing.T) {
+
+	s, sales := newTestBook(t)
+	first, second := sales[0], sales[1]
+
+	//no balanced entry takes back a lone line
+	lone := model.Debit(model.UserReceivable("u1", model.DefaultCurrency), 700)
+	lone.TransferID = first.ID
+	mustPostLine(t, s, lone)
+
+	//while the lines of the second sale can still be corrected
+	clearing := model.Debit(model.ClearingAccount(model.DefaultCurrency), 100)
+	clearing.TransferID = second.ID
+	mustPostLine(t, s, clearing)
+
+	payable := model.Credit(model.MerchantPayable("m1", model.DefaultCurrency), 100)
+	payable.TransferID = second.ID
+	mustPostLine(t, s, payable)
+
+	report := mustVerify(t, s, true)
+	if report.OK() {
+		t.Fatal("unbalanced posting reported repaired")
+	}
+
+	for _, m := range report.Mismatches {
+		if m.Repaired != (m.TransferIDs[0] == second.ID) {
+			t.Errorf("%s mismatch on %s of %v repaired %t", m.Kind, m.Name, m.TransferIDs, m.Repaired)
+		}
+	}
+
+	if n := adjustments(t, s); n != 1 {
+		t.Errorf("repair posted %d adjustments, want the one of the second sale", n)
+	}
+
+	report = mustVerify(t, s, false)
+	if len(report.Mismatches) != 2 {
+		t.Errorf("got mismatches %+v, want the receivable posting and the dues of the first sale", report.Mismatches)
+	}
+}
+
+func TestRepairRebuildsTamperedMerchantTotals(t *testing.T) {
+
+	s, _ := newTestBook(t)
+
+	totals, err := s.txns.GetMerchantTotals("m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := totals.DiscountEarned
+
+	tampered := *totals
+	tampered.DiscountEarned += 99
+	if _, err := s.db.Upsert(tampered); err != nil {
+		t.Fatal(err)
+	}
+
+	report := mustVerify(t, s, false)
+	if len(report.Mismatches) != 1 {
+		t.Fatalf("got mismatches %+v, want the discount of m1", report.Mismatches)
+	}
+
+	if m := report.Mismatches[0]; m.Kind != DISCOUNT_MISMATCH || m.Name != "m1" || m.Expected != want || m.Actual != want+99 || m.Repaired {
+		t.Errorf("got %+v, want an unrepaired discount mismatch of m1 from %s to %s", m, want+99, want)
+	}
+
+	if report = mustVerify(t, s, true); !report.OK() {
+		t.Fatalf("got mismatches %+v, want them all repaired", report.Mismatches)
+	}
+
+	rebuilt, err := s.txns.GetMerchantTotals("m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rebuilt.DiscountEarned != want {
+		t.Errorf("rebuilt discount %s, want %s", rebuilt.DiscountEarned, want)
+	}
+
+	assertClean(t, mustVerify(t, s, false))
+
+	if n := adjustments(t, s); n != 0 {
+		t.Errorf("rebuilding the totals posted %d adjustments", n)
+	}
+}